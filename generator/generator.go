@@ -4,47 +4,235 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"longden.me/blang/parser"
+	"longden.me/blang/scope"
 )
 
 type Variable struct {
-	name string
-	loc  int
+	name   string
+	loc    int
+	global bool
+}
+
+type Scope struct {
+	stack_size int
+	defers     []*parser.Node
 }
 
-type Stack []int
+type Stack []Scope
+
+type Loop struct {
+	end        string
+	depth      int // number of scopes outside the loop
+	stack_size int
+}
 
 type String struct {
 	name  string
 	value string
 }
 
+// Vtable is the list of methods a struct uses to implement an interface, in
+// the order the interface declares them.
+type Vtable struct {
+	label   string
+	methods []string
+}
+
 type Generator struct {
-	vars        []Variable
+	vars        *scope.Scope[Variable]
+	globals     *scope.Scope[Variable] // the outermost scope of the program
 	stack_size  int
 	scopes      Stack
+	loops       []Loop
+	in_function bool
 	output      string
 	label_count int
 	strings     []String
+	routines    map[string]bool
+	functions   string
+	vtables     []Vtable
+	source      string
+	checked     bool
 }
 
 func (g *Generator) find_var(s string) *Variable {
-	var variable *Variable
-	for _, v := range g.vars {
-		if v.name == s {
-			variable = &v
-			break
+	return g.vars.Lookup(s)
+}
+
+// declare_var adds a variable whose value is on top of the stack. Variables
+// declared at the top level of the program are globals, kept in .bss so that
+// functions can use them too.
+func (g *Generator) declare_var(name string) {
+	if g.vars != g.globals {
+		if _, ok := g.vars.Declare(name, Variable{name: name, loc: g.stack_size}); !ok {
+			panic("Variable already declared")
 		}
+		return
 	}
-	return variable
+
+	if _, ok := g.vars.Declare(name, Variable{name: name, global: true}); !ok {
+		panic("Variable already declared")
+	}
+	g.output += g.pop("rax")
+	g.output += "    mov [rel " + global_label(name) + "], rax\n"
+}
+
+func global_label(name string) string {
+	return "global_" + name
+}
+
+// ref returns the memory operand holding a variable.
+func (g *Generator) ref(variable *Variable) string {
+	if variable.global {
+		return "[rel " + global_label(variable.name) + "]"
+	}
+	return "[rsp + " + fmt.Sprint((g.stack_size-variable.loc)*8) + "]"
+}
+
+var arg_registers = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+
+// multiple results are returned in these registers, in order
+var ret_registers = []string{"rax", "rdx", "rcx", "r8", "r9", "r10"}
+
+// the helpers linked in from the runtime object. They aren't C functions, so
+// none of them is variadic in the C sense and al isn't set before calling them
+var externs = []string{"itoa", "print", "println"}
+
+// the instances of generic functions and types are named after their type
+// arguments, as in max[int], which can't be used in labels
+var label_escapes = strings.NewReplacer("[", "$l", "]", "$r", ", ", "$c")
+
+// function_label returns the label to call for a function. User functions are
+// prefixed so they can't clash with instructions or the runtime.
+func function_label(name string) string {
+	if find_routine(name) != nil {
+		return name
+	}
+	for _, extern := range externs {
+		if extern == name {
+			return name
+		}
+	}
+	return "fn_" + label_escapes.Replace(name)
 }
 
 func (g *Generator) gen_call(node *parser.Node) {
-	g.gen_term(node.Rhs.Lhs) // only supports one arg in rdi for now.
-	g.output += g.pop("rdi")
-	g.output += "    call " + node.Value + "\n"
-	g.output += g.push("rax", "function call result is in rax")
+	count := 0
+	for param := node.Rhs; param != nil; param = param.Rhs {
+		switch param.Lhs.Type {
+		case parser.NodeVarArgs:
+			// the variadic arguments are passed as a slice of them
+			g.gen_term(&parser.Node{Type: parser.NodeSliceLiteral, Rhs: param.Lhs.Rhs})
+		case parser.NodeSpread:
+			g.gen_term(param.Lhs.Lhs)
+		default:
+			g.gen_term(param.Lhs)
+		}
+		count++
+	}
+	if count > len(arg_registers) {
+		panic("Too many arguments in call to '" + node.Value + "'")
+	}
+	for i := count - 1; i >= 0; i-- {
+		g.output += g.pop(arg_registers[i])
+	}
+	if node.Type == parser.NodeDynamicCall {
+		// the receiver is an interface value, the struct is passed to the
+		// method found in its vtable
+		label := g.create_label()
+		g.output += "    cmp rdi, 0\n"
+		g.output += "    jne " + label + "\n"
+		g.gen_trap(node, "call to "+node.Value+" on an empty interface")
+		g.output += label + ":\n"
+		g.output += "    mov rax, [rdi + 8] ; vtable\n"
+		g.output += "    mov rdi, [rdi]\n"
+		g.output += "    call [rax + " + node.Lhs.Value + "*8] ; " + node.Value + "\n"
+		return
+	}
+	g.use_routine(node.Value)
+	g.output += "    call " + function_label(node.Value) + "\n"
+}
+
+// gen_vtable returns the label of the vtable of methods a struct implements an
+// interface with, adding it to the data section the first time it's used.
+func (g *Generator) gen_vtable(node *parser.Node) string {
+	label := "vtable_" + label_escapes.Replace(node.Rhs.Value+"."+node.Value)
+	for _, vtable := range g.vtables {
+		if vtable.label == label {
+			return label
+		}
+	}
+	vtable := Vtable{label: label}
+	for method := node.Rhs.Lhs; method != nil; method = method.Rhs {
+		vtable.methods = append(vtable.methods, function_label(method.Lhs.Value))
+	}
+	g.vtables = append(g.vtables, vtable)
+	return label
+}
+
+func (g *Generator) gen_function(node *parser.Node) {
+	// functions are generated apart from the code around them, with a stack of their own
+	output, vars, stack_size, scopes, loops := g.output, g.vars, g.stack_size, g.scopes, g.loops
+	g.output, g.vars, g.stack_size, g.scopes, g.loops = "", scope.New(g.globals), 0, nil, nil
+	g.in_function = true
+
+	g.output += function_label(node.Value) + ":\n"
+	i := 0
+	for param := node.Lhs; param != nil; param = param.Rhs {
+		g.output += g.push(arg_registers[i], "param "+param.Lhs.Value)
+		g.vars.Declare(param.Lhs.Value, Variable{name: param.Lhs.Value, loc: g.stack_size})
+		i++
+	}
+	g.gen_scope(node)
+	g.gen_return(nil) // in case the body doesn't end with one
+
+	g.functions += g.output
+	g.output, g.vars, g.stack_size, g.scopes, g.loops = output, vars, stack_size, scopes, loops
+	g.in_function = false
+}
+
+// gen_try calls a function returning a Result, leaving its value on the
+// stack. On an error the enclosing function returns it, or at the top level
+// the program prints it and exits.
+func (g *Generator) gen_try(node *parser.Node) {
+	g.gen_call(node.Lhs)
+	label := g.create_label()
+	g.output += "    ; try\n"
+	g.output += "    cmp rdx, 0\n"
+	g.output += "    je " + label + "\n"
+	g.output += g.push("rdx", "error")
+	g.gen_defers(0)
+	if g.in_function {
+		g.output += g.pop("rdx")
+		g.output += "    mov rax, 0\n"
+		g.output += "    add rsp, " + fmt.Sprint(g.stack_size*8) + " ; drop the function's stack\n"
+		g.output += "    ret\n"
+	} else {
+		g.use_routine("fail")
+		g.output += g.pop("rdi")
+		g.output += "    call fail\n"
+	}
+	g.output += label + ":\n"
+	g.output += g.push("rax", "result of try")
+}
+
+func (g *Generator) gen_return(node *parser.Node) {
+	count := 0
+	if node != nil {
+		for param := node.Lhs; param != nil; param = param.Rhs {
+			g.gen_term(param.Lhs)
+			count++
+		}
+	}
+	g.gen_defers(0)
+	for i := count - 1; i >= 0; i-- {
+		g.output += g.pop(ret_registers[i])
+	}
+	g.output += "    add rsp, " + fmt.Sprint(g.stack_size*8) + " ; drop the function's stack\n"
+	g.output += "    ret\n"
 }
 
 func (g *Generator) gen_term(node *parser.Node) {
@@ -52,8 +240,7 @@ func (g *Generator) gen_term(node *parser.Node) {
 		g.output += "    mov rax, " + node.Value + "\n"
 		g.output += g.push("rax", "push literal on stack")
 	} else if node.Type == parser.NodeStringLiteral {
-		label := g.create_label()
-		g.strings = append(g.strings, String{name: label, value: node.Value})
+		label := g.add_string(node.Value)
 		g.output += g.push(label, "string")
 
 	} else if node.Type == parser.NodeIdentifier {
@@ -62,15 +249,141 @@ func (g *Generator) gen_term(node *parser.Node) {
 			panic("No such variable, '" + node.Value + "'")
 		}
 		// found variable, get location
-		g.output += g.push("qword [rsp + "+fmt.Sprint((g.stack_size-variable.loc)*8)+"]", "push "+variable.name+" on stack")
-	} else if node.Type == parser.NodeCall {
+		g.output += g.push("qword "+g.ref(variable), "push "+variable.name+" on stack")
+	} else if node.Type == parser.NodeCall || node.Type == parser.NodeDynamicCall {
 		g.gen_call(node)
+		g.output += g.push("rax", "function call result is in rax")
+	} else if node.Type == parser.NodeInterfaceValue {
+		// interface values point to the struct and its vtable
+		g.gen_term(node.Lhs)
+		g.use_routine("alloc")
+		g.output += "    mov rdi, 16\n"
+		g.output += "    call alloc\n"
+		g.output += g.pop("rcx")
+		g.output += "    mov [rax], rcx\n"
+		g.output += "    lea rcx, [rel " + g.gen_vtable(node) + "]\n"
+		g.output += "    mov [rax + 8], rcx\n"
+		g.output += g.push("rax", node.Value)
+	} else if node.Type == parser.NodeNone {
+		g.output += g.push("0", "none")
+	} else if node.Type == parser.NodeSome {
+		// optionals point to their value, or are 0 for none
+		g.gen_term(node.Lhs)
+		g.use_routine("alloc")
+		g.output += "    mov rdi, 8\n"
+		g.output += "    call alloc\n"
+		g.output += g.pop("rcx")
+		g.output += "    mov [rax], rcx\n"
+		g.output += g.push("rax", "optional")
+	} else if node.Type == parser.NodeUnionValue {
+		// unions point to the index of their variant, followed by its values
+		// padded to the size of the largest
+		count := 1
+		for value := node.Rhs; value != nil; value = value.Rhs {
+			count++
+		}
+		g.use_routine("alloc")
+		g.output += "    mov rdi, " + fmt.Sprint(count*8) + "\n"
+		g.output += "    call alloc\n"
+		g.output += "    mov qword [rax], " + node.Lhs.Value + " ; variant\n"
+		g.output += g.push("rax", node.Value)
+		i := 1
+		for value := node.Rhs; value != nil; value = value.Rhs {
+			g.gen_term(value.Lhs)
+			g.output += g.pop("rcx")
+			g.output += "    mov rax, [rsp]\n"
+			g.output += "    mov [rax + " + fmt.Sprint(i*8) + "], rcx\n"
+			i++
+		}
+	} else if node.Type == parser.NodeTry {
+		g.gen_try(node)
+	} else if node.Type == parser.NodeSliceLiteral {
+		count := 0
+		for elem := node.Rhs; elem != nil; elem = elem.Rhs {
+			count++
+		}
+		g.use_routine("slice_make")
+		g.output += "    mov rdi, " + fmt.Sprint(count) + "\n"
+		g.output += "    call slice_make\n"
+		g.output += g.push("rax", "slice")
+		for elem := node.Rhs; elem != nil; elem = elem.Rhs {
+			g.gen_term(elem.Lhs)
+			g.output += g.pop("rsi")
+			g.output += "    mov rdi, [rsp]\n"
+			g.use_routine("append")
+			g.output += "    call append\n"
+		}
+	} else if node.Type == parser.NodeMapLiteral {
+		string_keys := "0"
+		if node.Lhs.Lhs.Value == "string" {
+			string_keys = "1"
+		}
+		g.use_routine("map_make")
+		g.output += "    mov rdi, " + string_keys + "\n"
+		g.output += "    call map_make\n"
+		g.output += g.push("rax", "map")
+		for entry := node.Rhs; entry != nil; entry = entry.Rhs {
+			g.gen_term(entry.Lhs.Lhs)
+			g.gen_term(entry.Lhs.Rhs)
+			g.output += g.pop("rdx")
+			g.output += g.pop("rsi")
+			g.output += "    mov rdi, [rsp]\n"
+			g.use_routine("map_set")
+			g.output += "    call map_set\n"
+		}
+	} else if node.Type == parser.NodeStructLiteral {
+		// structs are a pointer to their fields, which the type checker has
+		// put in order
+		count := 0
+		for field := node.Rhs; field != nil; field = field.Rhs {
+			count++
+		}
+		g.use_routine("alloc")
+		g.output += "    mov rdi, " + fmt.Sprint(max(count, 1)*8) + "\n"
+		g.output += "    call alloc\n"
+		g.output += g.push("rax", node.Value)
+		i := 0
+		for field := node.Rhs; field != nil; field = field.Rhs {
+			g.gen_term(field.Lhs.Rhs)
+			g.output += g.pop("rcx")
+			g.output += "    mov rax, [rsp]\n"
+			g.output += "    mov [rax + " + fmt.Sprint(i*8) + "], rcx ; " + field.Lhs.Lhs.Value + "\n"
+			i++
+		}
+	} else if node.Type == parser.NodeIndex || node.Type == parser.NodeField {
+		g.gen_address(node)
+		g.output += g.push("qword [rax]", "element")
+	} else if node.Type == parser.NodeSlice {
+		g.gen_slice(node)
+	} else if node.Type == parser.NodeIfExpr {
+		otherwise := g.create_label()
+		end := g.create_label()
+		test := g.gen_inverse_test(node.Lhs)
+		g.output += "    " + test + " " + otherwise + "\n"
+		g.gen_term(node.Rhs.Lhs)
+		g.output += "    jmp " + end + "\n"
+		g.output += otherwise + ":\n"
+		g.stack_size-- // only one of the branches leaves its value on the stack
+		g.gen_term(node.Rhs.Rhs)
+		g.output += end + ":\n"
+	} else if node.Type == parser.NodeInterpolate {
+		// join the parts, which the type checker has already made strings
+		g.gen_term(node.Lhs.Lhs)
+		for part := node.Lhs.Rhs; part != nil; part = part.Rhs {
+			g.gen_term(part.Lhs)
+			g.output += g.pop("rsi")
+			g.output += g.pop("rdi")
+			g.use_routine("concat")
+			g.output += "    call concat\n"
+			g.output += g.push("rax", "interpolated string")
+		}
 	} else if node.Type == parser.NodeAdd {
 		g.gen_term(node.Rhs)
 		g.gen_term(node.Lhs)
 		g.output += g.pop("rax")
 		g.output += g.pop("rbx")
 		g.output += "    add rax, rbx\n"
+		g.gen_overflow_check(node)
 		g.output += g.push("rax", "+")
 	} else if node.Type == parser.NodeSub {
 		g.gen_term(node.Rhs)
@@ -78,27 +391,176 @@ func (g *Generator) gen_term(node *parser.Node) {
 		g.output += g.pop("rax")
 		g.output += g.pop("rbx")
 		g.output += "    sub rax, rbx\n"
+		g.gen_overflow_check(node)
 		g.output += g.push("rax", "-")
 	} else if node.Type == parser.NodeMulti {
 		g.gen_term(node.Rhs)
 		g.gen_term(node.Lhs)
 		g.output += g.pop("rax")
 		g.output += g.pop("rbx")
-		g.output += "    mul rbx\n"
+		g.output += "    imul rax, rbx\n"
+		g.gen_overflow_check(node)
 		g.output += g.push("rax", "*")
 	} else if node.Type == parser.NodeDiv {
 		g.gen_term(node.Rhs)
 		g.gen_term(node.Lhs)
-		g.output += "    mov rdx, 0\n"
 		g.output += g.pop("rax")
 		g.output += g.pop("rbx")
-		g.output += "    div rbx\n"
+		g.gen_division_check(node)
+		g.output += "    cqo ; sign extend rax into rdx\n"
+		g.output += "    idiv rbx\n"
 		g.output += g.push("rax", "/")
+	} else if node.Type == parser.NodeMod {
+		g.gen_term(node.Rhs)
+		g.gen_term(node.Lhs)
+		g.output += g.pop("rax")
+		g.output += g.pop("rbx")
+		g.gen_division_check(node)
+		g.output += "    cqo ; sign extend rax into rdx\n"
+		g.output += "    idiv rbx\n"
+		g.output += g.push("rdx", "%, the remainder is in rdx")
 	} else {
 		panic("error parsing expression: " + fmt.Sprint(node))
 	}
 }
 
+// gen_address leaves the address of an element of a slice, or a field of a
+// struct, in rax.
+func (g *Generator) gen_address(node *parser.Node) {
+	if node.Type == parser.NodeField {
+		g.gen_term(node.Lhs)
+		g.output += g.pop("rax")
+		g.output += "    lea rax, [rax + " + node.Rhs.Value + "*8] ; " + node.Value + "\n"
+		return
+	}
+	g.gen_index(node)
+}
+
+// gen_index leaves the address of an element of a slice in rax, trapping if
+// the index is out of range.
+func (g *Generator) gen_index(node *parser.Node) {
+	g.gen_term(node.Lhs)
+	g.gen_term(node.Rhs)
+	g.output += g.pop("rax")
+	g.output += g.pop("rbx")
+	label := g.create_label()
+	g.output += "    cmp rax, [rbx + 8]\n"
+	g.output += "    jb " + label + " ; unsigned compare also catches negative indexes\n"
+	g.gen_trap(node, "index out of range")
+	g.output += label + ":\n"
+	g.output += "    mov rbx, [rbx]\n"
+	g.output += "    lea rax, [rbx + rax*8]\n"
+}
+
+// gen_slice makes a new slice sharing the elements of another, trapping if
+// the bounds are out of range.
+func (g *Generator) gen_slice(node *parser.Node) {
+	g.gen_term(node.Lhs)
+	if node.Rhs.Lhs != nil {
+		g.gen_term(node.Rhs.Lhs)
+	} else {
+		g.output += g.push("0", "slice from the start")
+	}
+	if node.Rhs.Rhs != nil {
+		g.gen_term(node.Rhs.Rhs)
+	} else {
+		g.output += "    mov rax, [rsp + 8]\n"
+		g.output += g.push("qword [rax + 8]", "slice to the end")
+	}
+	g.output += g.pop("rdx")
+	g.output += g.pop("rsi")
+	g.output += g.pop("rdi")
+
+	label := g.create_label()
+	okay := g.create_label()
+	g.output += "    cmp rsi, 0\n"
+	g.output += "    jl " + label + "\n"
+	g.output += "    cmp rsi, rdx\n"
+	g.output += "    jg " + label + "\n"
+	g.output += "    cmp rdx, [rdi + 8]\n"
+	g.output += "    jle " + okay + "\n"
+	g.output += label + ":\n"
+	g.gen_trap(node, "slice bounds out of range")
+	g.output += okay + ":\n"
+
+	g.use_routine("slice_sub")
+	g.output += "    call slice_sub\n"
+	g.output += g.push("rax", "slice")
+}
+
+// gen_compound updates the variable or element at target in place for a
+// compound assignment, x += y, with the value of y in rbx.
+func (g *Generator) gen_compound(node *parser.Node, target string) {
+	switch node.Value {
+	case "+":
+		g.output += "    add qword " + target + ", rbx\n"
+		g.gen_overflow_check(node)
+	case "-":
+		g.output += "    sub qword " + target + ", rbx\n"
+		g.gen_overflow_check(node)
+	case "*":
+		g.output += "    mov rax, " + target + "\n"
+		g.output += "    imul rax, rbx\n"
+		g.gen_overflow_check(node)
+		g.output += "    mov " + target + ", rax\n"
+	case "/", "%":
+		g.output += "    mov rax, " + target + "\n"
+		g.gen_division_check(node)
+		g.output += "    cqo ; sign extend rax into rdx\n"
+		g.output += "    idiv rbx\n"
+		if node.Value == "/" {
+			g.output += "    mov " + target + ", rax\n"
+		} else {
+			g.output += "    mov " + target + ", rdx\n"
+		}
+	default:
+		panic("Unknown compound assignment " + node.Value + "=")
+	}
+}
+
+// gen_trap calls the runtime to report an error at the position of the node
+// and exit.
+func (g *Generator) gen_trap(node *parser.Node, message string) {
+	g.use_routine("trap")
+	location := fmt.Sprintf("%s:%d:%d: %s", g.source, node.Line, node.Col+1, message)
+	g.output += "    lea rdi, [rel " + g.add_string(location) + "]\n"
+	g.output += "    call trap\n"
+}
+
+// gen_overflow_check traps if the last arithmetic instruction overflowed,
+// when checks are enabled.
+func (g *Generator) gen_overflow_check(node *parser.Node) {
+	if !g.checked {
+		return
+	}
+	label := g.create_label()
+	g.output += "    jno " + label + "\n"
+	g.gen_trap(node, "integer overflow")
+	g.output += label + ":\n"
+}
+
+// gen_division_check traps before dividing rax by rbx if rbx is zero, or if
+// the result would overflow, when checks are enabled.
+func (g *Generator) gen_division_check(node *parser.Node) {
+	if !g.checked {
+		return
+	}
+	label := g.create_label()
+	g.output += "    cmp rbx, 0\n"
+	g.output += "    jne " + label + "\n"
+	g.gen_trap(node, "division by zero")
+	g.output += label + ":\n"
+
+	label = g.create_label()
+	g.output += "    cmp rbx, -1\n"
+	g.output += "    jne " + label + "\n"
+	g.output += "    mov rcx, 0x8000000000000000 ; the smallest int, which has no positive\n"
+	g.output += "    cmp rax, rcx\n"
+	g.output += "    jne " + label + "\n"
+	g.gen_trap(node, "integer overflow")
+	g.output += label + ":\n"
+}
+
 func (g *Generator) gen_test(node *parser.Node) string {
 	g.gen_term(node.Lhs)
 	g.gen_term(node.Rhs)
@@ -133,6 +595,12 @@ func (g *Generator) gen_inverse_test(node *parser.Node) string {
 	return "jle"
 }
 
+func (g *Generator) add_string(value string) string {
+	label := g.create_label()
+	g.strings = append(g.strings, String{name: label, value: value})
+	return label
+}
+
 func (g *Generator) create_label() string {
 	label := "label" + strconv.Itoa(g.label_count)
 	g.label_count++
@@ -143,18 +611,31 @@ func (g *Generator) gen_expr(node *parser.Node) {
 	switch node.Type {
 	case parser.NodeExit:
 		g.gen_term(node.Lhs)
+		g.gen_defers(0)
 		//g.output += "    mov rax, 0x2000001 ; exit system call\n"
 		g.output += "    mov rax, 60 ; exit system call\n"
 		g.output += g.pop("rdi")
 		g.output += "    syscall\n"
 	case parser.NodeLet:
-		variable := g.find_var(node.Lhs.Value)
-		if variable != nil {
-			panic("Variable already declared")
+		if node.Lhs.Type == parser.NodeTuple {
+			g.gen_call(node.Rhs)
+			i := 0
+			for target := node.Lhs.Lhs; target != nil; target = target.Rhs {
+				if target.Lhs.Value != "_" {
+					g.output += g.push(ret_registers[i], target.Lhs.Value)
+					g.declare_var(target.Lhs.Value)
+				}
+				i++
+			}
+			break
+		}
+		if node.Lhs.Type != parser.NodeIdentifier {
+			g.gen_destructure(node)
+			break
 		}
-		g.gen_term(node.Rhs) // store value on stack
 
-		g.vars = append(g.vars, Variable{name: node.Lhs.Value, loc: g.stack_size})
+		g.gen_term(node.Rhs) // store value on stack
+		g.declare_var(node.Lhs.Value)
 	case parser.NodeScope:
 		g.gen_scope(node)
 	case parser.NodeIf:
@@ -163,16 +644,65 @@ func (g *Generator) gen_expr(node *parser.Node) {
 		label := g.create_label()
 		g.output += "    " + test + " " + label + "\n"
 		g.gen_scope(node)
+		if node.Rhs != nil {
+			end := g.create_label()
+			g.output += "    jmp " + end + "\n"
+			g.output += "    ;else\n" + label + ":\n"
+			g.gen_expr(node.Rhs)
+			label = end
+		}
 		g.output += "    ;endif\n" + label + ":\n"
+	case parser.NodeIfLet:
+		g.gen_if_let(node)
+	case parser.NodeMatch:
+		g.gen_match(node)
 	case parser.NodeAssign:
 		g.output += "    ; assignment\n"
+		if (node.Lhs.Type == parser.NodeIndex || node.Lhs.Type == parser.NodeField) && node.Value != "" {
+			g.gen_term(node.Rhs)
+			g.gen_address(node.Lhs)
+			g.output += "    mov rsi, rax\n"
+			g.output += g.pop("rbx")
+			g.gen_compound(node, "[rsi]")
+			break
+		}
+		if node.Lhs.Type == parser.NodeIndex || node.Lhs.Type == parser.NodeField {
+			g.gen_term(node.Rhs)
+			g.gen_address(node.Lhs)
+			g.output += g.pop("rcx")
+			g.output += "    mov [rax], rcx\n"
+			break
+		}
+		if node.Lhs.Type == parser.NodeTuple {
+			g.gen_call(node.Rhs)
+			i := 0
+			for target := node.Lhs.Lhs; target != nil; target = target.Rhs {
+				variable := g.find_var(target.Lhs.Value)
+				if variable == nil {
+					panic("Attempted assignment to undeclared variable")
+				}
+				g.output += "    mov qword " + g.ref(variable) + ", " + ret_registers[i] + "\n"
+				i++
+			}
+			break
+		}
 		variable := g.find_var(node.Lhs.Value)
 		if variable == nil {
 			panic("Attempted assignment to undeclared variable")
 		}
+		if node.Value != "" {
+			if node.Rhs.Type == parser.NodeIntLiteral {
+				g.output += "    mov rbx, " + node.Rhs.Value + "\n"
+			} else {
+				g.gen_term(node.Rhs)
+				g.output += g.pop("rbx")
+			}
+			g.gen_compound(node, g.ref(variable))
+			break
+		}
 		g.gen_term(node.Rhs)
 		g.output += g.pop("rax")
-		g.output += "    mov qword [rsp + " + fmt.Sprint((g.stack_size-variable.loc)*8) + "], rax\n"
+		g.output += "    mov qword " + g.ref(variable) + ", rax\n"
 
 	case parser.NodeFor:
 		g.output += "    ;for\n"
@@ -182,25 +712,277 @@ func (g *Generator) gen_expr(node *parser.Node) {
 		test := g.gen_inverse_test(node.Lhs)
 		g.output += "    " + test + " " + label_end + "\n"
 		g.output += label_start + ":\n"
+		g.loops = append(g.loops, Loop{end: label_end, depth: len(g.scopes), stack_size: g.stack_size})
 		g.gen_scope(node)
+		g.loops = g.loops[:len(g.loops)-1]
 		test = g.gen_test(node.Lhs)
 		g.output += "    " + test + " " + label_start + "\n"
 		g.output += "    ; endfor\n" + label_end + ":\n"
-	case parser.NodePrint:
-		g.gen_term(node.Lhs)
-		g.output += g.pop("rsi") // set arg for print
-		g.output += "    call print\n"
-	case parser.NodePrintln:
-		g.gen_term(node.Lhs)
-		g.output += g.pop("rsi") // set arg for print
-		g.output += "    call println\n"
-	case parser.NodeCall:
+	case parser.NodePrint, parser.NodePrintln:
+		g.gen_print(node)
+	case parser.NodeCall, parser.NodeDynamicCall:
 		g.gen_call(node)
+	case parser.NodeTry:
+		g.gen_try(node)
+		g.output += "    add rsp, 8 ; drop the value\n"
+		g.stack_size--
+	case parser.NodeFunction:
+		g.gen_function(node)
+	case parser.NodeImpl:
+		for i := range node.Stmts.Statements {
+			g.gen_function(&node.Stmts.Statements[i])
+		}
+	case parser.NodeGeneric:
+		// the instances the type checker made of a generic function or
+		// methods, generic structs have none
+		if node.Stmts != nil {
+			for i := range node.Stmts.Statements {
+				g.gen_function(&node.Stmts.Statements[i])
+			}
+		}
+	case parser.NodeStruct, parser.NodeInterface, parser.NodeUnion:
+		// only the type checker needs to know about types
+	case parser.NodeReturn:
+		g.gen_return(node)
+	case parser.NodeBreak:
+		if len(g.loops) == 0 {
+			panic("break outside of a loop")
+		}
+		loop := g.loops[len(g.loops)-1]
+		g.output += "    ; break\n"
+		g.gen_defers(loop.depth)
+		g.output += "    add rsp, " + fmt.Sprint((g.stack_size-loop.stack_size)*8) + "\n"
+		g.output += "    jmp " + loop.end + "\n"
+	case parser.NodeAssert:
+		g.gen_assert(node)
+	case parser.NodeDefer:
+		scope := &g.scopes[len(g.scopes)-1]
+		scope.defers = append(scope.defers, node.Lhs)
 	default:
 		panic("Can't generate expression")
 	}
 }
 
+// gen_assert prints "file:line:col: assertion failed: <expr>" and the
+// message, if there is one, to stderr and exits when the test fails.
+func (g *Generator) gen_assert(node *parser.Node) {
+	g.output += "    ; assert\n"
+	label := g.create_label()
+	test := g.gen_test(node.Lhs)
+	g.output += "    " + test + " " + label + "\n"
+
+	g.use_routine("fprint")
+	g.use_routine("fprintln")
+	location := fmt.Sprintf("%s:%d:%d: assertion failed: %s", g.source, node.Line, node.Col+1, node.Value)
+	g.output += "    mov rdi, 2 ; stderr\n"
+	g.output += "    lea rsi, [rel " + g.add_string(location) + "]\n"
+	g.output += "    call fprint\n"
+	if node.Rhs != nil {
+		g.output += "    mov rdi, 2\n"
+		g.output += "    lea rsi, [rel " + g.add_string(": ") + "]\n"
+		g.output += "    call fprint\n"
+		g.gen_term(node.Rhs.Lhs)
+		g.output += g.pop("rsi")
+		g.output += "    mov rdi, 2\n"
+		g.output += "    call fprint\n"
+	}
+	g.use_routine("empty_string")
+	g.output += "    mov rdi, 2\n"
+	g.output += "    lea rsi, [rel empty_string]\n"
+	g.output += "    call fprintln\n"
+	g.output += "    mov rax, 60 ; exit system call\n"
+	g.output += "    mov rdi, 134 ; the same status as an abort\n"
+	g.output += "    syscall\n"
+	g.output += label + ":\n"
+}
+
+func (g *Generator) gen_print_string(node *parser.Node) {
+	g.gen_term(node)
+	g.output += g.pop("rsi") // set arg for print
+	g.output += "    call print\n"
+}
+
+// gen_print prints each argument in turn, separated by a space.
+func (g *Generator) gen_print(node *parser.Node) {
+	for param := node.Lhs; param != nil; param = param.Rhs {
+		if param != node.Lhs {
+			g.use_routine("space_string")
+			g.output += "    lea rsi, [rel space_string]\n"
+			g.output += "    call print\n"
+		}
+		if param.Lhs.Type == parser.NodeInterpolate {
+			// no need to join the parts when printing them one after another
+			for part := param.Lhs.Lhs; part != nil; part = part.Rhs {
+				g.gen_print_string(part.Lhs)
+			}
+		} else {
+			g.gen_print_string(param.Lhs)
+		}
+	}
+
+	if node.Type == parser.NodePrintln {
+		g.use_routine("empty_string")
+		g.output += "    lea rsi, [rel empty_string]\n"
+		g.output += "    call println\n"
+	}
+}
+
+// gen_if_let runs the body of an if let with the value of the optional
+// declared in it, or the else branch if the optional is none.
+func (g *Generator) gen_if_let(node *parser.Node) {
+	g.output += "    ;if let\n"
+	label := g.create_label()
+	g.gen_term(node.Lhs.Rhs)
+	g.output += g.pop("rax")
+	g.output += "    cmp rax, 0\n"
+	g.output += "    je " + label + "\n"
+	g.begin_scope()
+	g.output += g.push("qword [rax]", node.Lhs.Lhs.Value)
+	g.declare_var(node.Lhs.Lhs.Value)
+	for i := 0; i < len(node.Stmts.Statements); i++ {
+		g.gen_expr(&node.Stmts.Statements[i])
+	}
+	g.end_scope()
+	if node.Rhs != nil {
+		end := g.create_label()
+		g.output += "    jmp " + end + "\n"
+		g.output += "    ;else\n" + label + ":\n"
+		g.gen_expr(node.Rhs)
+		label = end
+	}
+	g.output += "    ;endif\n" + label + ":\n"
+}
+
+// Binding is a variable bound by a pattern, to the value found by following
+// the offsets in path from the value being matched.
+type Binding struct {
+	name string
+	path []int
+}
+
+// gen_pattern tests the value returned by subject against a pattern, jumping
+// to next if it doesn't match, and adds the variables the pattern binds to
+// bindings. The value and the values inside it are loaded again for each
+// test, so nothing is left on the stack when a test fails.
+func (g *Generator) gen_pattern(pattern *parser.Node, subject func() string, path []int, next string, bindings *[]Binding) {
+	if pattern.Type == parser.NodeIdentifier {
+		if pattern.Value != "_" {
+			*bindings = append(*bindings, Binding{name: pattern.Value, path: path})
+		}
+		return
+	}
+	inner := func(offset int) []int {
+		return append(append([]int{}, path...), offset)
+	}
+	g.gen_load(subject(), path)
+	switch {
+	case pattern.Type == parser.NodeNone:
+		g.output += "    cmp rax, 0\n"
+		g.output += "    jne " + next + "\n"
+	case pattern.Type == parser.NodeIntLiteral:
+		g.output += "    cmp rax, " + pattern.Value + "\n"
+		g.output += "    jne " + next + "\n"
+	case pattern.Type == parser.NodeStructPattern:
+		// always matches, the type checker has made sure it's the right struct
+		for field := pattern.Rhs; field != nil; field = field.Rhs {
+			index, _ := strconv.Atoi(field.Lhs.Rhs.Value)
+			g.gen_pattern(field.Lhs.Lhs, subject, inner(index*8), next, bindings)
+		}
+	case pattern.Rhs != nil:
+		// a variant of a union, its values follow the index
+		g.output += "    cmp qword [rax], " + pattern.Rhs.Value + " ; " + pattern.Value + "\n"
+		g.output += "    jne " + next + "\n"
+		i := 1
+		for value := pattern.Lhs; value != nil; value = value.Rhs {
+			g.gen_pattern(value.Lhs, subject, inner(i*8), next, bindings)
+			i++
+		}
+	default:
+		// Some(v), an optional points to its value
+		g.output += "    cmp rax, 0\n"
+		g.output += "    je " + next + "\n"
+		g.gen_pattern(pattern.Lhs.Lhs, subject, inner(0), next, bindings)
+	}
+}
+
+// gen_load loads the value found by following the offsets in path from
+// operand into rax.
+func (g *Generator) gen_load(operand string, path []int) {
+	g.output += "    mov rax, " + operand + "\n"
+	for _, offset := range path {
+		g.output += "    mov rax, [rax + " + fmt.Sprint(offset) + "]\n"
+	}
+}
+
+// gen_bindings declares the variables bound by a pattern.
+func (g *Generator) gen_bindings(subject func() string, bindings []Binding) {
+	for _, binding := range bindings {
+		g.gen_load(subject(), binding.path)
+		g.output += g.push("rax", binding.name)
+		g.declare_var(binding.name)
+	}
+}
+
+// gen_match tests the patterns of each arm in turn against the value being
+// matched, which is kept in a hidden variable, and runs the first that
+// matches and whose guard holds. The type checker has made sure one of them
+// does.
+func (g *Generator) gen_match(node *parser.Node) {
+	g.output += "    ;match\n"
+	end := g.create_label()
+	g.begin_scope()
+	g.gen_term(node.Lhs)
+	g.declare_var("match value")
+	value := g.find_var("match value")
+	subject := func() string { return g.ref(value) }
+	for arm := node.Rhs; arm != nil; arm = arm.Rhs {
+		next := g.create_label()
+		var bindings []Binding
+		g.gen_pattern(arm.Lhs.Lhs, subject, nil, next, &bindings)
+		g.begin_scope()
+		g.gen_bindings(subject, bindings)
+		unguarded := ""
+		if arm.Lhs.Rhs != nil {
+			unguarded = g.create_label()
+			test := g.gen_inverse_test(arm.Lhs.Rhs)
+			g.output += "    " + test + " " + unguarded + "\n"
+		}
+		for i := 0; i < len(arm.Lhs.Stmts.Statements); i++ {
+			g.gen_expr(&arm.Lhs.Stmts.Statements[i])
+		}
+		g.end_scope()
+		g.output += "    jmp " + end + "\n"
+		if unguarded != "" {
+			// the guard failed with the bindings declared
+			g.output += unguarded + ":\n"
+			g.output += "    add rsp, " + fmt.Sprint(len(bindings)*8) + "\n"
+			g.output += "    jmp " + next + "\n"
+		}
+		g.output += next + ":\n"
+	}
+	g.output += end + ":\n"
+	g.end_scope()
+}
+
+// gen_destructure declares the variables bound by the pattern of a let. The
+// value being taken apart is left on the stack under them, unless they're
+// globals.
+func (g *Generator) gen_destructure(node *parser.Node) {
+	g.gen_term(node.Rhs)
+	value := g.stack_size
+	subject := func() string { return "[rsp + " + fmt.Sprint((g.stack_size-value)*8) + "]" }
+	var bindings []Binding
+	// the pattern always matches, but a variant of a union with only one
+	// still tests its index
+	matched := g.create_label()
+	g.gen_pattern(node.Lhs, subject, nil, matched, &bindings)
+	g.output += matched + ":\n"
+	g.gen_bindings(subject, bindings)
+	if g.vars == g.globals {
+		g.output += g.pop("rax")
+	}
+}
+
 func (g *Generator) gen_scope(node *parser.Node) {
 	g.begin_scope()
 	for i := 0; i < len(node.Stmts.Statements); i++ {
@@ -211,18 +993,34 @@ func (g *Generator) gen_scope(node *parser.Node) {
 
 func (g *Generator) begin_scope() {
 	g.output += "    ; scope begins\n"
-	g.scopes = append(g.scopes, g.stack_size)
+	g.scopes = append(g.scopes, Scope{stack_size: g.stack_size})
+	g.vars = scope.New(g.vars)
+}
+
+// gen_defers generates the deferred statements of every scope from the
+// innermost one out to depth, in the reverse order they were deferred.
+func (g *Generator) gen_defers(depth int) {
+	for i := len(g.scopes) - 1; i >= depth; i-- {
+		defers := g.scopes[i].defers
+		for j := len(defers) - 1; j >= 0; j-- {
+			// taken off the list while it's generated, so that it can never
+			// expand itself
+			g.scopes[i].defers = defers[:j]
+			g.output += "    ; deferred\n"
+			g.gen_expr(defers[j])
+		}
+		g.scopes[i].defers = defers
+	}
 }
 
 func (g *Generator) end_scope() {
-	target_size := g.scopes[len(g.scopes)-1]
-	pop_count := len(g.vars) - target_size
+	g.gen_defers(len(g.scopes) - 1)
+	target_size := g.scopes[len(g.scopes)-1].stack_size
+	pop_count := g.stack_size - target_size // everything left is a variable declared in the scope
 	g.output += "    ; scope ends\n"
 	g.output += "    add rsp, " + fmt.Sprint(pop_count*8) + "\n"
 	g.stack_size -= pop_count
-	if len(g.vars) > 0 {
-		g.vars = g.vars[:len(g.vars)-pop_count]
-	}
+	g.vars = g.vars.Parent()
 	g.scopes = g.scopes[:len(g.scopes)-1]
 }
 
@@ -240,25 +1038,71 @@ func (g *Generator) pop(reg string) string {
 
 func (g *Generator) assemble(stmts *parser.StatementSequence) {
 	// g.output = "global _main\nsection .text\n_main:\n"
-	g.output = "global _start\nsection .text\nextern itoa,print,println\n_start:\n"
+	g.output = "global _start\nsection .text\nextern " + strings.Join(externs, ",") + "\n_start:\n"
 
+	// the kernel leaves argc, then the argv and envp pointer arrays on the stack
+	g.output += "    mov rax, [rsp]\n"
+	g.output += "    mov [rel argc], rax\n"
+	g.output += "    lea rbx, [rsp + 8]\n"
+	g.output += "    mov [rel argv], rbx\n"
+	g.output += "    lea rbx, [rbx + rax*8 + 8] ; skip argv and its null terminator\n"
+	g.output += "    mov [rel envp], rbx\n"
+
+	g.begin_scope()
+	g.globals = g.vars
 	for i := 0; i < len(stmts.Statements); i++ {
 		g.gen_expr(&stmts.Statements[i])
 	}
+	g.end_scope()
 
 	// g.output += "    mov rax, 0x2000001 ; exit system call\n"
 	g.output += "    mov rax, 60 ; exit system call\n"
 	g.output += "    mov rdi, 0\n"
 	g.output += "    syscall\n"
 
+	g.output += g.functions
+
+	for _, routine := range runtime {
+		if g.routines[routine.name] {
+			g.output += routine.text
+		}
+	}
+
 	g.output += "section .data\n"
 	for i := 0; i < len(g.strings); i++ {
-		g.output += g.strings[i].name + " db \"" + g.strings[i].value + "\", 0\n"
+		g.output += g.strings[i].name + " db " + data_string(g.strings[i].value) + ", 0\n"
+	}
+	for _, vtable := range g.vtables {
+		methods := append(vtable.methods, "0") // so interfaces without methods have a vtable too
+		g.output += vtable.label + " dq " + strings.Join(methods, ", ") + "\n"
+	}
+	for _, routine := range runtime {
+		if g.routines[routine.name] {
+			g.output += routine.data
+		}
 	}
+
+	g.output += "section .bss\n"
+	g.output += "argc resq 1\nargv resq 1\nenvp resq 1\n"
+	for _, name := range g.globals.Names() {
+		g.output += global_label(name) + " resq 1\n"
+	}
+	for _, routine := range runtime {
+		if g.routines[routine.name] {
+			g.output += routine.bss
+		}
+	}
+}
+
+// data_string quotes a string for db, with any quotes in it written as bytes
+func data_string(value string) string {
+	return "\"" + strings.ReplaceAll(value, "\"", "\", 34, \"") + "\""
 }
 
-func Generate(stmts *parser.StatementSequence, fn string) {
-	g := Generator{}
+// Generate writes the assembly for a program to fn. When checked is set,
+// division by zero and integer overflow are trapped at runtime.
+func Generate(stmts *parser.StatementSequence, source string, fn string, checked bool) {
+	g := Generator{source: source, checked: checked}
 	g.assemble(stmts)
 
 	if err := os.WriteFile(fn, []byte(g.output), 0644); err != nil {
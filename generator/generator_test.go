@@ -0,0 +1,231 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"longden.me/blang/parser"
+	"longden.me/blang/tokeniser"
+)
+
+// assemble generates the assembly for a program that needs no lowering by the
+// type checker.
+func assemble(t *testing.T, src string, checked bool) string {
+	t.Helper()
+	tokens, err := tokeniser.Tokenise([]byte(src))
+	if err != nil {
+		t.Fatalf("unexpected token error: %s", err)
+	}
+	p := parser.Parser{Tokens: tokens}
+	ast, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected parse error: %s", err)
+	}
+	g := Generator{source: "test.bl", checked: checked}
+	g.assemble(ast)
+	return g.output
+}
+
+// expectInOrder checks that each of parts appears in output after the one
+// before it.
+func expectInOrder(t *testing.T, output string, parts ...string) {
+	t.Helper()
+	rest := output
+	for _, part := range parts {
+		i := strings.Index(rest, part)
+		if i == -1 {
+			t.Fatalf("expected %q after the parts before it in:\n%s", part, output)
+		}
+		rest = rest[i+len(part):]
+	}
+}
+
+const arithmetic = "a := 5\nb := a + 1\nc := a * b\nd := a / b\ne := a % b\nf := a - b\nb  += 1\nb++"
+
+func TestUncheckedArithmetic(t *testing.T) {
+	output := assemble(t, arithmetic, false)
+	for _, check := range []string{"jno", "cmp rbx, 0", "call trap", "integer overflow", "division by zero"} {
+		if strings.Contains(output, check) {
+			t.Errorf("unexpected %q without checks", check)
+		}
+	}
+}
+
+func TestCheckedOverflow(t *testing.T) {
+	output := assemble(t, arithmetic, true)
+	expectInOrder(t, output, "add rax, rbx\n    jno ", "call trap")
+	expectInOrder(t, output, "imul rax, rbx\n    jno ", "call trap")
+	expectInOrder(t, output, "sub rax, rbx\n    jno ", "call trap")
+	for _, message := range []string{"test.bl:2:8: integer overflow", "test.bl:3:8: integer overflow", "test.bl:6:8: integer overflow", "test.bl:7:4: integer overflow", "test.bl:8:2: integer overflow"} {
+		if !strings.Contains(output, message) {
+			t.Errorf("expected a trap message %q", message)
+		}
+	}
+}
+
+func TestCheckedDivision(t *testing.T) {
+	output := assemble(t, arithmetic, true)
+	// both division and remainder check the divisor before dividing
+	expectInOrder(t, output, "cmp rbx, 0\n    jne ", "call trap", "idiv rbx", "cmp rbx, 0\n    jne ", "call trap", "idiv rbx")
+	for _, message := range []string{"test.bl:4:8: division by zero", "test.bl:5:8: division by zero", "test.bl:4:8: integer overflow", "test.bl:5:8: integer overflow"} {
+		if !strings.Contains(output, message) {
+			t.Errorf("expected a trap message %q", message)
+		}
+	}
+}
+
+const globals = "var count = 2\nfn f() int {\n let count = 5\n return count\n}\nfn g() int {\n count = count + 1\n return count\n}\nexit count"
+
+// between returns the part of output from start up to end.
+func between(t *testing.T, output string, start string, end string) string {
+	t.Helper()
+	i := strings.Index(output, start)
+	if i == -1 {
+		t.Fatalf("expected %q in:\n%s", start, output)
+	}
+	j := strings.Index(output[i:], end)
+	if j == -1 {
+		t.Fatalf("expected %q after %q in:\n%s", end, start, output)
+	}
+	return output[i : i+j]
+}
+
+func TestTopLevelVariablesAreGlobals(t *testing.T) {
+	output := assemble(t, globals, false)
+	expectInOrder(t, output, "_start:", "pop rax\n    mov [rel global_count], rax\n", "push qword [rel global_count] ; push count on stack\n", "fn_f:")
+	expectInOrder(t, output, "section .bss\n", "global_count resq 1\n")
+
+	g := between(t, output, "fn_g:", "section .data")
+	expectInOrder(t, g, "push qword [rel global_count] ; push count on stack\n", "mov qword [rel global_count], rax\n")
+	if strings.Contains(g, "[rsp") {
+		t.Errorf("expected g to use the global and nothing on its stack")
+	}
+}
+
+func TestLocalHidesGlobal(t *testing.T) {
+	output := assemble(t, globals, false)
+	f := between(t, output, "fn_f:", "fn_g:")
+	if strings.Contains(f, "global_count") {
+		t.Errorf("expected the local count to hide the global in f")
+	}
+	expectInOrder(t, f, "push qword [rsp + 0] ; push count on stack\n")
+}
+
+func TestNestedScopeAtTopLevelIsLocal(t *testing.T) {
+	output := assemble(t, "let a = 1\n{\n let b = 2\n println \"x\"\n}", false)
+	expectInOrder(t, output, "global_a resq 1\n")
+	if strings.Contains(output, "global_b") {
+		t.Errorf("expected b to be on the stack rather than a global")
+	}
+}
+
+func TestInnerVariableHidesOuter(t *testing.T) {
+	output := assemble(t, "fn f() int {\n let x = 1\n let y = 2\n {\n let x = 3\n println x\n }\n return x\n}", false)
+	expectInOrder(t, output,
+		"fn_f:",
+		// the inner x is on top of the stack
+		"push rax ; push literal on stack\n    push qword [rsp + 0] ; push x on stack\n",
+		"; scope ends\n    add rsp, 8\n",
+		// and the outer one is back under y once it's dropped
+		"push qword [rsp + 8] ; push x on stack\n",
+	)
+}
+
+func TestVariablesOfEndedScopeAreDropped(t *testing.T) {
+	output := assemble(t, "fn f() {\n {\n let a = 1\n let b = 2\n println \"x\"\n }\n let c = 3\n println \"y\"\n}", false)
+	expectInOrder(t, output,
+		"fn_f:",
+		"; scope ends\n    add rsp, 16\n",
+		// so the function's scope only has c left to drop
+		"; scope ends\n    add rsp, 8\n",
+	)
+}
+
+func TestCompoundAssignmentInPlace(t *testing.T) {
+	output := assemble(t, "var g = 1\nfn f() int {\n i := 2\n i += 3\n i++\n g += 4\n g++\n return i\n}\nexit f()", false)
+	f := between(t, output, "fn_f:", "push qword [rsp + 0] ; push i on stack")
+	expectInOrder(t, f,
+		"mov rbx, 3\n    add qword [rsp + 0], rbx\n",
+		"mov rbx, 1\n    add qword [rsp + 0], rbx\n",
+		"mov rbx, 4\n    add qword [rel global_g], rbx\n",
+		"mov rbx, 1\n    add qword [rel global_g], rbx\n",
+	)
+	// only the initial value of i goes through the stack
+	if strings.Count(f, "    push ") != 1 || strings.Contains(f, "    pop ") {
+		t.Errorf("expected the updates not to go through the stack in:\n%s", f)
+	}
+}
+
+func TestAssertionMessageShowsNone(t *testing.T) {
+	output := assemble(t, "m := 1\nassert m == none", false)
+	if !strings.Contains(output, "assertion failed: m == none") {
+		t.Errorf("expected the assertion text in the message")
+	}
+}
+
+func TestDeferDoesNotExpandItself(t *testing.T) {
+	// rejected by the type checker, but mustn't hang the generator either
+	assemble(t, "fn f() { defer { return } println \"x\" }", false)
+	assemble(t, "x := 0\nfor x < 3 { defer { break } x++ }", false)
+}
+
+// expectRoutine checks that a runtime routine appears exactly once in output.
+func expectRoutine(t *testing.T, output string, name string) {
+	t.Helper()
+	if n := strings.Count(output, "\n"+name+":\n"); n != 1 {
+		t.Errorf("expected routine %s once, found it %d times", name, n)
+	}
+}
+
+func TestReadFileRoutines(t *testing.T) {
+	output := assemble(t, "s := read_file(\"x\")\nt := read_file(\"y\")\nprintln s, t", false)
+	// read_file brings in what it calls, and what those call in turn
+	for _, name := range []string{"read_file", "open", "read", "close", "alloc", "trap", "fprintln"} {
+		expectRoutine(t, output, name)
+	}
+	if strings.Contains(output, "stdin_getc:") {
+		t.Errorf("expected only the routines read_file needs")
+	}
+	// a pipe can't be sized up front, so it's read into a buffer that doubles
+	expectInOrder(t, output, "read_file:\n", "jl .stream\n", ".stream:\n    mov r12, 4096", "lea rdi, [r12*2 + 1]", "call alloc", ".copy:\n")
+	expectInOrder(t, output, "alloc:\n", "mov rax, 9 ; mmap system call\n    syscall\n", "cmp rax, -4095", "jae .failed\n", ".failed:\n    lea rdi, [rel alloc_failed]\n    call trap\n")
+	expectInOrder(t, output, "section .data", "alloc_failed db \"out of memory\", 0\n")
+}
+
+func TestStdinRoutines(t *testing.T) {
+	output := assemble(t, "l := readln()\nn := read_int()\nexit eof()", false)
+	for _, name := range []string{"readln", "read_int", "eof", "stdin_getc", "read", "alloc"} {
+		expectRoutine(t, output, name)
+	}
+	if strings.Count(output, "stdin_buf resb 4096\n") != 1 {
+		t.Errorf("expected one 4096 byte buffer shared by the routines reading stdin")
+	}
+	expectInOrder(t, output, "\nstdin_getc:\n", "lea rsi, [rel stdin_buf]\n    mov rdx, 4096\n    call read\n")
+}
+
+func TestPrintSeparatesArgumentsWithSpace(t *testing.T) {
+	output := assemble(t, "println \"a\", 1, \"b\"", false)
+	space := "    lea rsi, [rel space_string]\n    call print\n"
+	if strings.Count(output, space) != 2 {
+		t.Errorf("expected a space between each of the three arguments in:\n%s", output)
+	}
+	expectInOrder(t, output, "call print\n", space, "push rax ; push literal on stack\n", "call print\n", space, "call print\n", "call println\n")
+	expectInOrder(t, output, "section .data", "space_string db \" \", 0\n")
+
+	output = assemble(t, "print \"a\"", false)
+	if strings.Contains(output, "space_string") {
+		t.Errorf("expected no space after a single argument")
+	}
+}
+
+func TestStartSavesProcessStack(t *testing.T) {
+	output := assemble(t, "a := args()\nexit len(a)", false)
+	start := between(t, output, "_start:", "; scope begins")
+	// the first thing on the stack is argc, followed by argv and envp
+	expectInOrder(t, start, "mov rax, [rsp]\n    mov [rel argc], rax\n", "lea rbx, [rsp + 8]\n    mov [rel argv], rbx\n", "mov [rel envp], rbx\n")
+	if strings.Contains(start, "push") {
+		t.Errorf("expected nothing pushed before the process stack is saved")
+	}
+	expectInOrder(t, output, "args:\n", "call slice_make", ".next:\n", "mov rsi, [r12]\n", "call append")
+	expectInOrder(t, output, "section .bss\n", "argc resq 1\n", "argv resq 1\n", "envp resq 1\n")
+}
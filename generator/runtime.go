@@ -0,0 +1,972 @@
+package generator
+
+// Routine is a piece of the blang runtime written in assembly. Routines are
+// only emitted into the output when the program calls them (directly, or
+// through another routine that depends on them).
+type Routine struct {
+	name string
+	deps []string
+	text string
+	data string
+	bss  string
+}
+
+// std/io: thin wrappers around the linux syscalls plus a few helpers built on
+// top of them. Arguments arrive in rdi, rsi, rdx in the same order the
+// syscalls expect them, results are returned in rax.
+var runtime = []Routine{
+	{
+		name: "open",
+		text: `open:
+    mov rdx, 420 ; 0644 for any file created
+    mov rax, 2 ; open system call
+    syscall
+    ret
+`,
+	},
+	{
+		name: "read",
+		text: `read:
+    mov rax, 0 ; read system call
+    syscall
+    ret
+`,
+	},
+	{
+		name: "write",
+		text: `write:
+    mov rax, 1 ; write system call
+    syscall
+    ret
+`,
+	},
+	{
+		name: "close",
+		text: `close:
+    mov rax, 3 ; close system call
+    syscall
+    ret
+`,
+	},
+	{
+		// bump allocator handing out zeroed memory from chunks mapped with mmap,
+		// trapping if no more can be mapped
+		name: "alloc",
+		deps: []string{"trap"},
+		text: `alloc:
+    add rdi, 15
+    and rdi, -16 ; keep allocations 16 byte aligned
+    mov rax, [rel heap_ptr]
+    mov rdx, [rel heap_end]
+    lea rcx, [rax + rdi]
+    cmp rcx, rdx
+    jae .grow
+    mov [rel heap_ptr], rcx
+    ret
+.grow:
+    mov rsi, 1048576 ; map at least 1MB at a time
+    cmp rdi, rsi
+    cmova rsi, rdi
+    push rdi
+    push rsi
+    mov rdi, 0
+    mov rdx, 3 ; PROT_READ | PROT_WRITE
+    mov r10, 34 ; MAP_PRIVATE | MAP_ANONYMOUS
+    mov r8, -1
+    mov r9, 0
+    mov rax, 9 ; mmap system call
+    syscall
+    pop rsi
+    pop rdi
+    cmp rax, -4095 ; errors are returned as -4095 to -1
+    jae .failed
+    lea rcx, [rax + rdi]
+    mov [rel heap_ptr], rcx
+    add rsi, rax
+    mov [rel heap_end], rsi
+    ret
+.failed:
+    lea rdi, [rel alloc_failed]
+    call trap
+`,
+		data: "alloc_failed db \"out of memory\", 0\n",
+		bss:  "heap_ptr resq 1\nheap_end resq 1\n",
+	},
+	{
+		name: "strlen",
+		text: `strlen:
+    mov rax, 0
+.next:
+    cmp byte [rdi + rax], 0
+    je .done
+    inc rax
+    jmp .next
+.done:
+    ret
+`,
+	},
+	{
+		name: "byte_at",
+		text: `byte_at:
+    movzx rax, byte [rdi + rsi]
+    ret
+`,
+	},
+	{
+		name: "fprint",
+		deps: []string{"strlen", "write"},
+		text: `fprint:
+    push rdi
+    push rsi
+    mov rdi, rsi
+    call strlen
+    mov rdx, rax
+    pop rsi
+    pop rdi
+    jmp write
+`,
+	},
+	{
+		name: "fprintln",
+		deps: []string{"fprint", "write"},
+		text: `fprintln:
+    push rdi
+    call fprint
+    pop rdi
+    lea rsi, [rel newline]
+    mov rdx, 1
+    jmp write
+`,
+		data: "newline db 10\n",
+	},
+	{
+		// returns the whole file as a string, or an empty string if it can't be
+		// opened. Files that can't seek, such as pipes, are read into a buffer
+		// that doubles in size whenever it fills up.
+		name: "read_file",
+		deps: []string{"open", "read", "close", "alloc"},
+		text: `read_file:
+    push rbx
+    push r12
+    push r13
+    push r14
+    mov rsi, 0 ; O_RDONLY
+    call open
+    cmp rax, 0
+    jl .empty
+    mov rbx, rax ; fd
+    mov rdi, rbx
+    mov rsi, 0
+    mov rdx, 2 ; SEEK_END
+    mov rax, 8 ; lseek system call
+    syscall
+    cmp rax, 0
+    jl .stream
+    mov r12, rax ; file size
+    mov rdi, rbx
+    mov rsi, 0
+    mov rdx, 0 ; SEEK_SET
+    mov rax, 8
+    syscall
+    lea rdi, [r12 + 1] ; room for the null terminator
+    call alloc
+    mov r13, rax
+    mov r14, 0 ; bytes read so far
+.read:
+    cmp r14, r12
+    jge .done
+    mov rdi, rbx
+    lea rsi, [r13 + r14]
+    mov rdx, r12
+    sub rdx, r14
+    call read
+    cmp rax, 0
+    jle .done
+    add r14, rax
+    jmp .read
+.stream:
+    mov r12, 4096 ; size of the buffer
+    lea rdi, [r12 + 1]
+    call alloc
+    mov r13, rax
+    mov r14, 0 ; bytes read so far
+.stream_read:
+    cmp r14, r12
+    jl .stream_room
+    lea rdi, [r12*2 + 1] ; full, so copy it into one twice the size
+    call alloc
+    mov rcx, 0
+.copy:
+    cmp rcx, r14
+    jge .copied
+    mov dl, [r13 + rcx]
+    mov [rax + rcx], dl
+    inc rcx
+    jmp .copy
+.copied:
+    mov r13, rax
+    shl r12, 1
+.stream_room:
+    mov rdi, rbx
+    lea rsi, [r13 + r14]
+    mov rdx, r12
+    sub rdx, r14
+    call read
+    cmp rax, 0
+    jle .done
+    add r14, rax
+    jmp .stream_read
+.done:
+    mov rdi, rbx
+    call close
+    mov rax, r13
+    jmp .return
+.empty:
+    mov rdi, 1
+    call alloc
+.return:
+    pop r14
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// joins two strings into a newly allocated one
+		name: "concat",
+		deps: []string{"strlen", "alloc"},
+		text: `concat:
+    push rbx
+    push r12
+    push r13
+    push r14
+    mov rbx, rdi
+    mov r12, rsi
+    call strlen
+    mov r13, rax ; length of the first string
+    mov rdi, r12
+    call strlen
+    mov r14, rax ; length of the second string
+    lea rdi, [r13 + r14 + 1]
+    call alloc
+    mov rdi, rax
+    mov rsi, rbx
+    mov rcx, r13
+    rep movsb
+    mov rsi, r12
+    mov rcx, r14
+    rep movsb
+    pop r14
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// errors are their message, no error is a null pointer
+		name: "error",
+		text: `error:
+    mov rax, rdi
+    ret
+`,
+	},
+	{
+		name: "error_text",
+		deps: []string{"empty_string"},
+		text: `error_text:
+    mov rax, rdi
+    cmp rax, 0
+    jne .done
+    lea rax, [rel empty_string]
+.done:
+    ret
+`,
+	},
+	{
+		// prints an error to stderr and exits
+		name: "fail",
+		deps: []string{"fprint", "fprintln"},
+		text: `fail:
+    push rdi
+    mov rdi, 2 ; stderr
+    lea rsi, [rel fail_prefix]
+    call fprint
+    pop rsi
+    mov rdi, 2
+    call fprintln
+    mov rax, 60 ; exit system call
+    mov rdi, 1
+    syscall
+`,
+		data: "fail_prefix db \"error: \", 0\n",
+	},
+	{
+		// slices are a pointer to a header of the pointer to their elements,
+		// their length and their capacity
+		name: "slice_make",
+		deps: []string{"alloc"},
+		text: `slice_make:
+    push rbx
+    push r12
+    mov r12, rdi ; capacity
+    cmp r12, 4
+    jge .sized
+    mov r12, 4
+.sized:
+    mov rdi, 24
+    call alloc
+    mov rbx, rax
+    lea rdi, [r12*8]
+    call alloc
+    mov [rbx], rax
+    mov qword [rbx + 8], 0
+    mov [rbx + 16], r12
+    mov rax, rbx
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// appends in place, growing the slice when it's full, and returns it
+		name: "append",
+		deps: []string{"alloc"},
+		text: `append:
+    push rbx
+    push r12
+    push r13
+    mov rbx, rdi
+    mov r12, rsi
+    mov rcx, [rbx + 8]
+    cmp rcx, [rbx + 16]
+    jl .store
+    mov r13, [rbx + 16] ; full, so move the elements somewhere twice the size
+    shl r13, 1
+    cmp r13, 4
+    jge .grow
+    mov r13, 4
+.grow:
+    lea rdi, [r13*8]
+    call alloc
+    mov rsi, [rbx]
+    mov rdi, rax
+    mov rcx, [rbx + 8]
+    rep movsq
+    mov [rbx], rax
+    mov [rbx + 16], r13
+.store:
+    mov rcx, [rbx + 8]
+    mov rax, [rbx]
+    mov [rax + rcx*8], r12
+    inc qword [rbx + 8]
+    mov rax, rbx
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// maps keep their number of keys in the same place as slices
+		name: "len",
+		text: `len:
+    mov rax, [rdi + 8]
+    ret
+`,
+	},
+	{
+		// a new header for elements low to high of a slice, sharing its elements
+		name: "slice_sub",
+		deps: []string{"alloc"},
+		text: `slice_sub:
+    push rdi
+    push rsi
+    push rdx
+    mov rdi, 24
+    call alloc
+    pop rdx
+    pop rsi
+    pop rdi
+    mov rcx, [rdi]
+    lea rcx, [rcx + rsi*8]
+    mov [rax], rcx
+    mov rcx, rdx
+    sub rcx, rsi
+    mov [rax + 8], rcx
+    mov rcx, [rdi + 16]
+    sub rcx, rsi
+    mov [rax + 16], rcx
+    ret
+`,
+	},
+	{
+		// compares two strings, rax is 1 if they're equal
+		name: "streq",
+		text: `streq:
+    mov rcx, 0
+.next:
+    mov al, [rdi + rcx]
+    cmp al, [rsi + rcx]
+    jne .differ
+    inc rcx
+    cmp al, 0
+    jne .next
+    mov rax, 1
+    ret
+.differ:
+    mov rax, 0
+    ret
+`,
+	},
+	{
+		// maps are a pointer to a header of the pointer to their entries, the
+		// number of keys, the capacity, whether the keys are strings and the
+		// number of entries in use including deleted ones. Entries are a state
+		// (0 empty, 1 full, 2 deleted), the key and the value.
+		name: "map_make",
+		deps: []string{"alloc"},
+		text: `map_make:
+    push rbx
+    push r12
+    mov r12, rdi
+    mov rdi, 40
+    call alloc
+    mov rbx, rax
+    mov qword [rbx + 16], 8
+    mov [rbx + 24], r12
+    mov rdi, 8 * 24
+    call alloc
+    mov [rbx], rax
+    mov rax, rbx
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		name: "map_hash",
+		text: `map_hash:
+    cmp qword [rdi + 24], 0
+    jne .string
+    mov rax, rsi
+    mov rdx, 0x9e3779b97f4a7c15
+    imul rax, rdx
+    shr rax, 32
+    ret
+.string:
+    mov rax, 0xcbf29ce484222325 ; FNV-1a
+    mov rdx, 0x100000001b3
+.next:
+    movzx rcx, byte [rsi]
+    cmp rcx, 0
+    je .done
+    xor rax, rcx
+    imul rax, rdx
+    inc rsi
+    jmp .next
+.done:
+    ret
+`,
+	},
+	{
+		// finds the entry for a key, rdx is 1 if it's there. If it isn't, rax
+		// is the entry it should go in.
+		name: "map_find",
+		deps: []string{"map_hash", "streq"},
+		text: `map_find:
+    push rbx
+    push r12
+    push r13
+    push r14
+    push r15
+    mov rbx, rdi
+    mov r12, rsi
+    call map_hash
+    mov r13, [rbx + 16]
+    dec r13 ; the capacity is a power of two, so this masks the index
+    and rax, r13
+    mov r14, rax
+    mov r15, 0 ; the first deleted entry passed, to be reused
+.probe:
+    imul rax, r14, 24
+    add rax, [rbx]
+    cmp qword [rax], 0
+    je .empty
+    cmp qword [rax], 2
+    je .deleted
+    cmp qword [rbx + 24], 0
+    jne .string
+    cmp [rax + 8], r12
+    je .found
+    jmp .next
+.string:
+    push rax
+    mov rdi, r12
+    mov rsi, [rax + 8]
+    call streq
+    mov rcx, rax
+    pop rax
+    cmp rcx, 0
+    jne .found
+    jmp .next
+.deleted:
+    cmp r15, 0
+    jne .next
+    mov r15, rax
+.next:
+    inc r14
+    and r14, r13
+    jmp .probe
+.empty:
+    cmp r15, 0
+    je .missing
+    mov rax, r15
+.missing:
+    mov rdx, 0
+    jmp .done
+.found:
+    mov rdx, 1
+.done:
+    pop r15
+    pop r14
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// moves the entries of a map into twice the space, dropping deleted ones
+		name: "map_grow",
+		deps: []string{"alloc", "map_find"},
+		text: `map_grow:
+    push rbx
+    push r12
+    push r13
+    mov rbx, rdi
+    mov r12, [rbx]
+    mov r13, [rbx + 16]
+    imul rdi, r13, 2 * 24
+    call alloc
+    mov [rbx], rax
+    shl qword [rbx + 16], 1
+    mov rax, [rbx + 8]
+    mov [rbx + 32], rax
+.next:
+    cmp r13, 0
+    je .done
+    cmp qword [r12], 1
+    jne .skip
+    mov rdi, rbx
+    mov rsi, [r12 + 8]
+    call map_find
+    mov qword [rax], 1
+    mov rcx, [r12 + 8]
+    mov [rax + 8], rcx
+    mov rcx, [r12 + 16]
+    mov [rax + 16], rcx
+.skip:
+    add r12, 24
+    dec r13
+    jmp .next
+.done:
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		name: "map_set",
+		deps: []string{"map_find", "map_grow"},
+		text: `map_set:
+    push rbx
+    push r12
+    push r13
+    mov rbx, rdi
+    mov r12, rsi
+    mov r13, rdx
+    mov rax, [rbx + 32] ; keep at most three quarters of the entries in use,
+    inc rax             ; so probing always ends at an empty one
+    shl rax, 2
+    imul rcx, [rbx + 16], 3
+    cmp rax, rcx
+    jle .find
+    mov rdi, rbx
+    call map_grow
+.find:
+    mov rdi, rbx
+    mov rsi, r12
+    call map_find
+    cmp rdx, 0
+    jne .store
+    cmp qword [rax], 0
+    jne .reuse
+    inc qword [rbx + 32]
+.reuse:
+    inc qword [rbx + 8]
+    mov qword [rax], 1
+    mov [rax + 8], r12
+.store:
+    mov [rax + 16], r13
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// the value for a key, or the zero value in rdx if it's missing, with
+		// rdx set to 1 if the key was found
+		name: "map_lookup",
+		deps: []string{"map_find"},
+		text: `map_lookup:
+    push rdx
+    call map_find
+    pop rcx
+    cmp rdx, 0
+    je .missing
+    mov rax, [rax + 16]
+    ret
+.missing:
+    mov rax, rcx
+    ret
+`,
+	},
+	{
+		// the value only, as in m[k]
+		name: "map_get",
+		deps: []string{"map_lookup"},
+		text: `map_get:
+    jmp map_lookup
+`,
+	},
+	{
+		name: "delete",
+		deps: []string{"map_find"},
+		text: `delete:
+    push rdi
+    call map_find
+    pop rdi
+    cmp rdx, 0
+    je .done
+    mov qword [rax], 2 ; marked rather than emptied so probing carries on past it
+    dec qword [rdi + 8]
+.done:
+    ret
+`,
+	},
+	{
+		// the keys of a map as a slice, in no particular order
+		name: "keys",
+		deps: []string{"slice_make", "append"},
+		text: `keys:
+    push rbx
+    push r12
+    push r13
+    push r14
+    mov rbx, rdi
+    mov rdi, [rbx + 8]
+    call slice_make
+    mov r14, rax
+    mov r12, [rbx]
+    mov r13, [rbx + 16]
+.next:
+    cmp r13, 0
+    je .done
+    cmp qword [r12], 1
+    jne .skip
+    mov rdi, r14
+    mov rsi, [r12 + 8]
+    call append
+.skip:
+    add r12, 24
+    dec r13
+    jmp .next
+.done:
+    mov rax, r14
+    pop r14
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// reports a runtime error, the message in rdi, and exits
+		name: "trap",
+		deps: []string{"fprintln"},
+		text: `trap:
+    mov rsi, rdi
+    mov rdi, 2 ; stderr
+    call fprintln
+    mov rax, 60 ; exit system call
+    mov rdi, 134
+    syscall
+`,
+	},
+	{
+		name: "empty_string",
+		data: "empty_string db 0\n",
+	},
+	{
+		// printed between the arguments of print and println
+		name: "space_string",
+		data: "space_string db \" \", 0\n",
+	},
+	{
+		// argc, argv and envp are captured from the initial process stack in
+		// _start. The arguments are already null terminated strings, so the
+		// slice points at them rather than copying them
+		name: "args",
+		deps: []string{"slice_make", "append"},
+		text: `args:
+    push rbx
+    push r12
+    mov rdi, [rel argc]
+    call slice_make
+    mov rbx, rax
+    mov r12, [rel argv]
+.next:
+    mov rsi, [r12]
+    cmp rsi, 0
+    je .done
+    mov rdi, rbx
+    call append
+    add r12, 8
+    jmp .next
+.done:
+    mov rax, rbx
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// returns an empty string when the index is out of range
+		name: "arg",
+		deps: []string{"empty_string"},
+		text: `arg:
+    cmp rdi, [rel argc]
+    jae .missing ; unsigned compare also catches negative indexes
+    mov rax, [rel argv]
+    mov rax, [rax + rdi*8]
+    ret
+.missing:
+    lea rax, [rel empty_string]
+    ret
+`,
+	},
+	{
+		// returns the value of an environment variable, or an empty string if it isn't set
+		name: "env",
+		deps: []string{"empty_string"},
+		text: `env:
+    mov rsi, [rel envp]
+.next:
+    mov rdx, [rsi] ; "NAME=value"
+    cmp rdx, 0
+    je .missing
+    mov rcx, 0
+.compare:
+    mov al, [rdi + rcx]
+    cmp al, 0
+    je .end_of_name
+    cmp al, [rdx + rcx]
+    jne .skip
+    inc rcx
+    jmp .compare
+.end_of_name:
+    cmp byte [rdx + rcx], 61 ; '='
+    jne .skip
+    lea rax, [rdx + rcx + 1]
+    ret
+.skip:
+    add rsi, 8
+    jmp .next
+.missing:
+    lea rax, [rel empty_string]
+    ret
+`,
+	},
+	{
+		// next byte from stdin in rax, or -1 at the end of the input
+		name: "stdin_getc",
+		deps: []string{"read"},
+		text: `stdin_getc:
+    mov rax, [rel stdin_pos]
+    cmp rax, [rel stdin_len]
+    jl .ready
+    mov rdi, 0 ; stdin
+    lea rsi, [rel stdin_buf]
+    mov rdx, 4096
+    call read
+    cmp rax, 0
+    jle .eof
+    mov [rel stdin_len], rax
+    mov qword [rel stdin_pos], 0
+    mov rax, 0
+.ready:
+    lea rcx, [rel stdin_buf]
+    movzx rax, byte [rcx + rax]
+    inc qword [rel stdin_pos]
+    ret
+.eof:
+    mov rax, -1
+    ret
+`,
+		bss: "stdin_buf resb 4096\nstdin_pos resq 1\nstdin_len resq 1\n",
+	},
+	{
+		// 1 if there's nothing left to read from stdin, otherwise 0, reading
+		// more into the buffer if it's empty so there's something to check
+		name: "eof",
+		deps: []string{"stdin_getc"},
+		text: `eof:
+    mov rax, [rel stdin_pos]
+    cmp rax, [rel stdin_len]
+    jl .more
+    mov rdi, 0 ; stdin
+    lea rsi, [rel stdin_buf]
+    mov rdx, 4096
+    call read
+    cmp rax, 0
+    jle .end
+    mov [rel stdin_len], rax
+    mov qword [rel stdin_pos], 0
+.more:
+    mov rax, 0
+    ret
+.end:
+    mov rax, 1
+    ret
+`,
+	},
+	{
+		// reads up to the next newline, which is dropped from the returned string
+		name: "readln",
+		deps: []string{"stdin_getc", "alloc"},
+		text: `readln:
+    push rbx
+    push r12
+    push r13
+    mov r13, 128 ; capacity
+    mov rdi, r13
+    call alloc
+    mov rbx, rax ; line
+    mov r12, 0 ; length
+.next:
+    call stdin_getc
+    cmp rax, -1
+    je .done
+    cmp rax, 10 ; newline
+    je .done
+    lea rcx, [r12 + 1] ; keep room for the null terminator
+    cmp rcx, r13
+    jl .store
+    push rax
+    shl r13, 1
+    mov rdi, r13
+    call alloc
+    mov rcx, 0
+.copy:
+    mov dl, [rbx + rcx]
+    mov [rax + rcx], dl
+    inc rcx
+    cmp rcx, r12
+    jl .copy
+    mov rbx, rax
+    pop rax
+.store:
+    mov [rbx + r12], al
+    inc r12
+    jmp .next
+.done:
+    mov rax, rbx
+    pop r13
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+	{
+		// skips leading whitespace, reads an optionally signed integer and
+		// consumes the whitespace character following it, if there is one
+		name: "read_int",
+		deps: []string{"stdin_getc"},
+		text: `read_int:
+    push rbx
+    push r12
+.skip:
+    call stdin_getc
+    cmp rax, 32 ; space
+    je .skip
+    cmp rax, 9 ; tab through to carriage return
+    jl .sign
+    cmp rax, 13
+    jle .skip
+.sign:
+    mov r12, 1
+    cmp rax, 45 ; '-'
+    jne .first
+    mov r12, -1
+    call stdin_getc
+.first:
+    mov rbx, 0
+.digit:
+    cmp rax, 48 ; '0'
+    jl .done
+    cmp rax, 57 ; '9'
+    jg .done
+    imul rbx, rbx, 10
+    sub rax, 48
+    add rbx, rax
+    call stdin_getc
+    jmp .digit
+.done:
+    cmp rax, -1
+    je .return
+    cmp rax, 32 ; space
+    je .return
+    cmp rax, 9 ; tab through to carriage return
+    jl .unread
+    cmp rax, 13
+    jle .return
+.unread:
+    dec qword [rel stdin_pos] ; leave anything else for the next read
+.return:
+    mov rax, rbx
+    imul rax, r12
+    pop r12
+    pop rbx
+    ret
+`,
+	},
+}
+
+func find_routine(name string) *Routine {
+	for i := range runtime {
+		if runtime[i].name == name {
+			return &runtime[i]
+		}
+	}
+	return nil
+}
+
+// use_routine marks a runtime routine, and everything it depends on, as
+// needed in the output. Names that aren't part of the runtime are ignored.
+func (g *Generator) use_routine(name string) {
+	routine := find_routine(name)
+	if routine == nil || g.routines[name] {
+		return
+	}
+	if g.routines == nil {
+		g.routines = map[string]bool{}
+	}
+	g.routines[name] = true
+	for _, dep := range routine.deps {
+		g.use_routine(dep)
+	}
+}
@@ -5,60 +5,2332 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"slices"
+	"strings"
 
 	"longden.me/blang/generator"
 	"longden.me/blang/parser"
+	"longden.me/blang/scope"
 	"longden.me/blang/tokeniser"
 )
 
-type VarType int
+// VarType is the name of a type as it's written in the source, so composite
+// types like []int can be compared directly.
+type VarType string
 
 const (
-	Int VarType = iota
-	String
+	Int    VarType = "int"
+	String VarType = "string"
+	Error  VarType = "error"
+	None   VarType = "none" // the type of none, until it's used as an optional
 )
 
+func SliceOf(elem VarType) VarType {
+	return "[]" + elem
+}
+
+func (ty VarType) IsSlice() bool {
+	return strings.HasPrefix(string(ty), "[]")
+}
+
+// Elem returns the element type of a slice type.
+func (ty VarType) Elem() VarType {
+	return ty[2:]
+}
+
+func OptionalOf(ty VarType) VarType {
+	return "?" + ty
+}
+
+func (ty VarType) IsOptional() bool {
+	return strings.HasPrefix(string(ty), "?")
+}
+
+// Unwrap returns the type of the value of an optional type.
+func (ty VarType) Unwrap() VarType {
+	return ty[1:]
+}
+
+func MapOf(key VarType, value VarType) VarType {
+	return "map[" + key + "]" + value
+}
+
+func (ty VarType) IsMap() bool {
+	return strings.HasPrefix(string(ty), "map[")
+}
+
+// Key returns the key type of a map type, which is always an int or a string.
+func (ty VarType) Key() VarType {
+	return ty[4:strings.Index(string(ty), "]")]
+}
+
+// Value returns the value type of a map type.
+func (ty VarType) Value() VarType {
+	return ty[strings.Index(string(ty), "]")+1:]
+}
+
+// TypeNode converts a type back into the nodes it would be parsed from.
+func TypeNode(ty VarType) *parser.Node {
+	if ty.IsSlice() {
+		return &parser.Node{Type: parser.NodeSliceType, Lhs: TypeNode(ty.Elem())}
+	}
+	if ty.IsMap() {
+		return &parser.Node{Type: parser.NodeMapType, Lhs: TypeNode(ty.Key()), Rhs: TypeNode(ty.Value())}
+	}
+	if ty.IsOptional() {
+		return &parser.Node{Type: parser.NodeOptionalType, Lhs: TypeNode(ty.Unwrap())}
+	}
+	return &parser.Node{Type: parser.NodeTypeName, Value: string(ty)}
+}
+
+// ZeroValue returns an expression for the value of a type when there isn't
+// one, such as looking up a key that's missing from a map.
+func (tc *TypeChecker) ZeroValue(ty VarType) *parser.Node {
+	if ty == String {
+		return &parser.Node{Type: parser.NodeStringLiteral}
+	}
+	if ty.IsSlice() {
+		return &parser.Node{Type: parser.NodeSliceLiteral, Lhs: TypeNode(ty)}
+	}
+	if ty.IsMap() {
+		return &parser.Node{Type: parser.NodeMapLiteral, Lhs: TypeNode(ty)}
+	}
+	if ty.IsOptional() {
+		return &parser.Node{Type: parser.NodeNone}
+	}
+	if ty == Int || ty == Error || tc.FindInterface(string(ty)) != nil {
+		return &parser.Node{Type: parser.NodeIntLiteral, Value: "0"} // also no error, or no value
+	}
+	if u := tc.FindUnion(string(ty)); u != nil {
+		// the first variant, with its values zero
+		values := make([]*parser.Node, u.Size())
+		for i := range values {
+			values[i] = &parser.Node{Type: parser.NodeIntLiteral, Value: "0"}
+			if i < len(u.Variants[0].Types) {
+				values[i] = tc.ZeroValue(u.Variants[0].Types[i])
+			}
+		}
+		return &parser.Node{Type: parser.NodeUnionValue, Value: u.Name, Lhs: &parser.Node{Type: parser.NodeIntLiteral, Value: "0"}, Rhs: Params(values...)}
+	}
+	// anything else is a struct, with all of its fields zero
+	return &parser.Node{Type: parser.NodeStructLiteral, Value: string(ty)}
+}
+
+// Params chains nodes together as the arguments of a call.
+func Params(nodes ...*parser.Node) *parser.Node {
+	var head *parser.Node
+	for i := len(nodes) - 1; i >= 0; i-- {
+		head = &parser.Node{Type: parser.NodeParam, Lhs: nodes[i], Rhs: head}
+	}
+	return head
+}
+
 type Variable struct {
-	Name string
-	Type VarType
+	Name    string
+	Type    VarType
+	Mutable bool // declared with var or :=, rather than let
+	Line    int
+	Col     int
+}
+
+// Struct is a user defined type, a pointer to its fields in the order they're
+// declared.
+type Struct struct {
+	Name     string
+	Fields   []Variable
+	Template string    // the generic struct this is an instance of, if any
+	Args     []VarType // and its type arguments
+}
+
+// Field finds a field of a struct, returning its index as well.
+func (s *Struct) Field(name string) (int, *Variable) {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return i, &s.Fields[i]
+		}
+	}
+	return -1, nil
+}
+
+// Interface is a set of methods, which any struct with methods of the same
+// names and signatures implements. Its methods are declared as functions
+// named after the interface, as in Writer.write.
+type Interface struct {
+	Name    string
+	Methods []string
+}
+
+// Method finds a method of an interface, returning its index in the vtable.
+func (i *Interface) Method(name string) int {
+	for j := range i.Methods {
+		if i.Methods[j] == name {
+			return j
+		}
+	}
+	return -1
+}
+
+// Union is a user defined type holding one of its variants, each with its own
+// values. It's a pointer to a tag word, the index of the variant, followed by
+// room for the values of the largest variant.
+type Union struct {
+	Name     string
+	Variants []Variant
+}
+
+type Variant struct {
+	Name  string
+	Types []VarType
+}
+
+// Variant finds a variant of a union, returning its index as well.
+func (u *Union) Variant(name string) (int, *Variant) {
+	for i := range u.Variants {
+		if u.Variants[i].Name == name {
+			return i, &u.Variants[i]
+		}
+	}
+	return -1, nil
+}
+
+// Size is the number of values of the largest variant.
+func (u *Union) Size() int {
+	size := 0
+	for _, v := range u.Variants {
+		size = max(size, len(v.Types))
+	}
+	return size
+}
+
+type Function struct {
+	Name     string
+	Params   []VarType
+	Returns  []VarType
+	Result   bool // returns a Result[T], a value and an error
+	Variadic bool // the last parameter is a slice of the rest of the arguments
+}
+
+// Templates holds the generic functions and structs of a program, and the
+// instances made of them for each set of type arguments they're used with. It's
+// shared by every checker, so that each instance is only made once.
+type Templates struct {
+	functions map[string]*parser.Node   // generic functions, by name
+	types     map[string]*parser.Node   // generic structs, by name
+	impls     map[string][]*parser.Node // the methods of each generic struct
+	instances map[string]*Function      // instances of functions and methods, as in max[int]
+	structs   map[string]*Struct        // instances of structs, as in Stack[int]
+	// the globals each template can see, once the checker has reached it, and
+	// the instances waiting to be checked until then
+	scopes  map[*parser.Node]*scope.Scope[Variable]
+	pending map[*parser.Node][]*parser.Node
+	depth   int
+}
+
+// instances can use other instances, but only so many deep, so a generic that
+// uses itself with ever larger type arguments is an error
+const max_instance_depth = 32
+
+// Instance names an instance of a generic function or struct after its type
+// arguments.
+func Instance(name string, args []VarType) VarType {
+	names := make([]string, len(args))
+	for i := range args {
+		names[i] = string(args[i])
+	}
+	return VarType(name + "[" + strings.Join(names, ", ") + "]")
+}
+
+// Substitute copies a generic function or type, replacing its type parameters
+// with the types they're bound to.
+func Substitute(node *parser.Node, bindings map[string]VarType) *parser.Node {
+	if node == nil {
+		return nil
+	}
+	if ty, ok := bindings[node.Value]; ok && node.Type == parser.NodeTypeName && node.Lhs == nil {
+		return TypeNode(ty)
+	}
+	copy := *node
+	copy.Lhs = Substitute(node.Lhs, bindings)
+	copy.Rhs = Substitute(node.Rhs, bindings)
+	if node.Stmts != nil {
+		copy.Stmts = &parser.StatementSequence{}
+		for i := range node.Stmts.Statements {
+			copy.Stmts.Statements = append(copy.Stmts.Statements, *Substitute(&node.Stmts.Statements[i], bindings))
+		}
+	}
+	return &copy
+}
+
+// Bind pairs the type parameters of a generic with the type arguments given
+// to it.
+func Bind(template *parser.Node, args []VarType) (map[string]VarType, error) {
+	bindings := map[string]VarType{}
+	i := 0
+	for param := template.Lhs; param != nil; param = param.Rhs {
+		if i < len(args) {
+			bindings[param.Lhs.Value] = args[i]
+		}
+		i++
+	}
+	if i != len(args) {
+		return nil, fmt.Errorf("'%s' expects %d type arguments, got %d", template.Value, i, len(args))
+	}
+	return bindings, nil
+}
+
+// Built in functions, provided either by the runtime emitted by the generator
+// or linked in from the x86-64 objects.
+var builtins = []Function{
+	{Name: "itoa", Params: []VarType{Int}, Returns: []VarType{String}},
+	{Name: "open", Params: []VarType{String, Int}, Returns: []VarType{Int}},
+	{Name: "read", Params: []VarType{Int, String, Int}, Returns: []VarType{Int}},
+	{Name: "write", Params: []VarType{Int, String, Int}, Returns: []VarType{Int}},
+	{Name: "close", Params: []VarType{Int}, Returns: []VarType{Int}},
+	{Name: "alloc", Params: []VarType{Int}, Returns: []VarType{String}},
+	{Name: "strlen", Params: []VarType{String}, Returns: []VarType{Int}},
+	{Name: "fprint", Params: []VarType{Int, String}, Returns: []VarType{Int}},
+	{Name: "fprintln", Params: []VarType{Int, String}, Returns: []VarType{Int}},
+	{Name: "read_file", Params: []VarType{String}, Returns: []VarType{String}},
+	{Name: "args", Params: []VarType{}, Returns: []VarType{SliceOf(String)}},
+	{Name: "arg", Params: []VarType{Int}, Returns: []VarType{String}},
+	{Name: "env", Params: []VarType{String}, Returns: []VarType{String}},
+	{Name: "readln", Params: []VarType{}, Returns: []VarType{String}},
+	{Name: "read_int", Params: []VarType{}, Returns: []VarType{Int}},
+	{Name: "eof", Params: []VarType{}, Returns: []VarType{Int}},
+	{Name: "concat", Params: []VarType{String, String}, Returns: []VarType{String}},
+	{Name: "error", Params: []VarType{String}, Returns: []VarType{Error}},
+	// used to convert errors to strings, as no error is a null pointer
+	{Name: "error_text", Params: []VarType{Error}, Returns: []VarType{String}},
+	// used to iterate over strings, the index must be within the string or
+	// at its terminating zero
+	{Name: "byte_at", Params: []VarType{String, Int}, Returns: []VarType{Int}},
+}
+
+func (tc *TypeChecker) ParseType(node *parser.Node) (VarType, error) {
+	if node.Type == parser.NodeMapType {
+		key, err := tc.ParseType(node.Lhs)
+		if err != nil {
+			return Int, err
+		}
+		if key != Int && key != String {
+			return Int, fmt.Errorf("map keys must be an int or a string, got %s", key)
+		}
+		value, err := tc.ParseType(node.Rhs)
+		if err != nil {
+			return Int, err
+		}
+		return MapOf(key, value), nil
+	}
+	if node.Type == parser.NodeSliceType {
+		elem, err := tc.ParseType(node.Lhs)
+		if err != nil {
+			return Int, err
+		}
+		return SliceOf(elem), nil
+	}
+	if node.Type == parser.NodeOptionalType {
+		ty, err := tc.ParseType(node.Lhs)
+		if err != nil {
+			return Int, err
+		}
+		return OptionalOf(ty), nil
+	}
+	if node.Type == parser.NodeVariadicType {
+		elem, err := tc.ParseType(node.Lhs)
+		if err != nil {
+			return Int, err
+		}
+		return SliceOf(elem), nil
+	}
+
+	if template := tc.templates.types[node.Value]; template != nil {
+		if node.Lhs == nil {
+			return Int, fmt.Errorf("generic type '%s' needs type arguments", node.Value)
+		}
+		return tc.InstantiateStruct(template, node.Lhs)
+	}
+	if node.Lhs != nil && node.Value == "Result" {
+		return Int, fmt.Errorf("type '%s' can only be returned from a function", node.Value)
+	}
+	if node.Lhs != nil {
+		return Int, fmt.Errorf("type '%s' doesn't take type arguments", node.Value)
+	}
+
+	switch node.Value {
+	case "int":
+		return Int, nil
+	case "string":
+		return String, nil
+	case "error":
+		return Error, nil
+	}
+	if tc.FindStruct(node.Value) != nil || tc.FindInterface(node.Value) != nil || tc.FindUnion(node.Value) != nil {
+		return VarType(node.Value), nil
+	}
+	return Int, fmt.Errorf("unknown type '%s'", node.Value)
+}
+
+type TypeChecker struct {
+	scope      *scope.Scope[Variable] // the outermost scope holds the globals
+	functions  []Function
+	structs    []Struct
+	interfaces []Interface
+	unions     []Union
+	templates  *Templates
+	function   *Function // the function being checked, nil outside of one
+	nested     bool
+	loop       bool
+}
+
+func (tc *TypeChecker) FindFunction(name string) *Function {
+	for i := range tc.functions {
+		if tc.functions[i].Name == name {
+			return &tc.functions[i]
+		}
+	}
+	for i := range builtins {
+		if builtins[i].Name == name {
+			return &builtins[i]
+		}
+	}
+	return tc.templates.instances[name]
+}
+
+// FindVariable looks for the innermost variable with a name, which may be in
+// an enclosing scope or a global.
+func (tc *TypeChecker) FindVariable(name string) *Variable {
+	return tc.scope.Lookup(name)
+}
+
+// Declare adds a variable to the current scope. Variables can hide those in
+// enclosing scopes, but can't be declared twice in the same one.
+func (tc *TypeChecker) Declare(v Variable) error {
+	existing, ok := tc.scope.Declare(v.Name, v)
+	if !ok {
+		return fmt.Errorf("'%s' already declared at line %d, col %d", v.Name, existing.Line, existing.Col)
+	}
+	return nil
+}
+
+// DeclareLet adds a variable declared by a let, var or := statement.
+func (tc *TypeChecker) DeclareLet(let *parser.Node, id *parser.Node, ty VarType) error {
+	return tc.Declare(Variable{Name: id.Value, Type: ty, Mutable: let.Value == "var", Line: id.Line, Col: id.Col})
+}
+
+// CheckMutable checks that the target of an assignment isn't a variable
+// declared with let. The elements of slices and maps can always be assigned.
+func (tc *TypeChecker) CheckMutable(target *parser.Node) error {
+	if target.Type != parser.NodeIdentifier {
+		return nil
+	}
+	v := tc.FindVariable(target.Value)
+	if v != nil && !v.Mutable {
+		return fmt.Errorf("can't assign to '%s', declared with let at line %d, col %d", v.Name, v.Line, v.Col)
+	}
+	return nil
+}
+
+// ReturnsResult reports whether a function returns a Result, which mustn't be
+// dropped.
+func (tc *TypeChecker) ReturnsResult(name string) bool {
+	fn := tc.FindFunction(name)
+	return fn != nil && fn.Result
+}
+
+func (tc *TypeChecker) FindStruct(name string) *Struct {
+	for i := range tc.structs {
+		if tc.structs[i].Name == name {
+			return &tc.structs[i]
+		}
+	}
+	return tc.templates.structs[name]
+}
+
+func (tc *TypeChecker) FindInterface(name string) *Interface {
+	for i := range tc.interfaces {
+		if tc.interfaces[i].Name == name {
+			return &tc.interfaces[i]
+		}
+	}
+	return nil
+}
+
+func (tc *TypeChecker) FindUnion(name string) *Union {
+	for i := range tc.unions {
+		if tc.unions[i].Name == name {
+			return &tc.unions[i]
+		}
+	}
+	return nil
+}
+
+// DeclareTypes adds the structs, interfaces and unions declared in a program, and the
+// generic functions and structs. They're all named before any fields are
+// checked, so they can refer to each other. The methods of interfaces are
+// declared along with the other functions.
+func (tc *TypeChecker) DeclareTypes(seq *parser.StatementSequence) error {
+	for i := range seq.Statements {
+		node := &seq.Statements[i]
+		decl := node
+		if node.Type == parser.NodeGeneric {
+			decl = node.Rhs
+			err := CheckTypeParams(node)
+			if err != nil {
+				return err
+			}
+			if decl.Type != parser.NodeStruct {
+				// the instances of generic functions and methods are kept
+				// with them, to be generated in their place
+				node.Stmts = &parser.StatementSequence{}
+			}
+		}
+		switch {
+		case node.Type == parser.NodeGeneric && decl.Type == parser.NodeFunction:
+			if tc.FindFunction(node.Value) != nil || IsGeneric(node.Value) || tc.templates.functions[node.Value] != nil {
+				return fmt.Errorf("function '%s' already declared", node.Value)
+			}
+			tc.templates.functions[node.Value] = node
+			continue
+		case node.Type == parser.NodeGeneric && decl.Type == parser.NodeImpl:
+			tc.templates.impls[node.Value] = append(tc.templates.impls[node.Value], node)
+			continue
+		case decl.Type != parser.NodeStruct && decl.Type != parser.NodeInterface && decl.Type != parser.NodeUnion:
+			continue
+		}
+		switch node.Value {
+		case "int", "string", "error", "Result":
+			return fmt.Errorf("can't declare type '%s', it's a built in type", node.Value)
+		}
+		if tc.FindStruct(node.Value) != nil || tc.FindInterface(node.Value) != nil || tc.FindUnion(node.Value) != nil || tc.templates.types[node.Value] != nil {
+			return fmt.Errorf("type '%s' already declared", node.Value)
+		}
+		if node.Type == parser.NodeGeneric {
+			tc.templates.types[node.Value] = node
+		} else if node.Type == parser.NodeInterface {
+			tc.interfaces = append(tc.interfaces, Interface{Name: node.Value})
+		} else if node.Type == parser.NodeUnion {
+			tc.unions = append(tc.unions, Union{Name: node.Value})
+		} else {
+			tc.structs = append(tc.structs, Struct{Name: node.Value})
+		}
+	}
+
+	for i := range seq.Statements {
+		node := &seq.Statements[i]
+		var err error
+		if node.Type == parser.NodeStruct {
+			err = tc.DeclareFields(tc.FindStruct(node.Value), node)
+		} else if node.Type == parser.NodeUnion {
+			err = tc.DeclareVariants(tc.FindUnion(node.Value), node)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// every field has a value, so a struct can't contain itself
+	for _, s := range tc.structs {
+		if tc.Contains(VarType(s.Name), s.Name, map[string]bool{}) {
+			return fmt.Errorf("struct '%s' can't contain itself", s.Name)
+		}
+	}
+	// and neither can the first variant of a union, its zero value
+	for _, u := range tc.unions {
+		if tc.Contains(VarType(u.Name), u.Name, map[string]bool{}) {
+			return fmt.Errorf("the first variant of union '%s' can't contain it", u.Name)
+		}
+	}
+	return nil
+}
+
+// DeclareVariants adds the variants of a union declaration to the union.
+func (tc *TypeChecker) DeclareVariants(u *Union, node *parser.Node) error {
+	for variant := node.Lhs; variant != nil; variant = variant.Rhs {
+		v := Variant{Name: variant.Lhs.Value}
+		for param := variant.Lhs.Lhs; param != nil; param = param.Rhs {
+			ty, err := tc.ParseType(param.Lhs)
+			if err != nil {
+				return err
+			}
+			v.Types = append(v.Types, ty)
+		}
+		if _, existing := u.Variant(v.Name); existing != nil {
+			return fmt.Errorf("variant '%s' already declared in union '%s'", v.Name, u.Name)
+		}
+		u.Variants = append(u.Variants, v)
+	}
+	return nil
+}
+
+// DeclareFields adds the fields of a struct declaration to the struct.
+func (tc *TypeChecker) DeclareFields(s *Struct, node *parser.Node) error {
+	for field := node.Lhs; field != nil; field = field.Rhs {
+		ty, err := tc.ParseType(field.Lhs.Lhs)
+		if err != nil {
+			return err
+		}
+		if _, existing := s.Field(field.Lhs.Value); existing != nil {
+			return fmt.Errorf("field '%s' already declared in struct '%s'", field.Lhs.Value, s.Name)
+		}
+		s.Fields = append(s.Fields, Variable{Name: field.Lhs.Value, Type: ty, Mutable: true, Line: field.Lhs.Line, Col: field.Lhs.Col})
+	}
+	return nil
+}
+
+// CheckTypeParams checks that the type parameters of a generic are all named
+// differently.
+func CheckTypeParams(node *parser.Node) error {
+	seen := map[string]bool{}
+	for param := node.Lhs; param != nil; param = param.Rhs {
+		if seen[param.Lhs.Value] {
+			return fmt.Errorf("type parameter '%s' of '%s' declared twice", param.Lhs.Value, node.Value)
+		}
+		seen[param.Lhs.Value] = true
+	}
+	return nil
+}
+
+// CheckGenericImpl checks that the methods of a generic type are declared for
+// a generic struct, with the same number of type parameters.
+func (tc *TypeChecker) CheckGenericImpl(node *parser.Node) error {
+	template := tc.templates.types[node.Value]
+	if template == nil {
+		return fmt.Errorf("methods declared for unknown generic type '%s'", node.Value)
+	}
+	var params []VarType
+	for param := node.Lhs; param != nil; param = param.Rhs {
+		params = append(params, VarType(param.Lhs.Value))
+	}
+	_, err := Bind(template, params)
+	return err
+}
+
+// InstantiateStruct makes the instance of a generic struct for some type
+// arguments, as in Stack[int], along with its methods, the first time it's
+// used.
+func (tc *TypeChecker) InstantiateStruct(template *parser.Node, type_args *parser.Node) (VarType, error) {
+	var args []VarType
+	for arg := type_args; arg != nil; arg = arg.Rhs {
+		ty, err := tc.ParseType(arg.Lhs)
+		if err != nil {
+			return Int, err
+		}
+		args = append(args, ty)
+	}
+	bindings, err := Bind(template, args)
+	if err != nil {
+		return Int, err
+	}
+	name := Instance(template.Value, args)
+	if tc.FindStruct(string(name)) != nil {
+		return name, nil
+	}
+	if tc.templates.depth >= max_instance_depth {
+		return Int, fmt.Errorf("too many nested instances of '%s'", template.Value)
+	}
+	tc.templates.depth++
+	defer func() { tc.templates.depth-- }()
+
+	// added before its fields, which may refer to it
+	s := &Struct{Name: string(name), Template: template.Value, Args: args}
+	tc.templates.structs[s.Name] = s
+	err = tc.DeclareFields(s, Substitute(template.Rhs, bindings))
+	if err != nil {
+		return Int, err
+	}
+	if tc.Contains(name, s.Name, map[string]bool{}) {
+		return Int, fmt.Errorf("struct '%s' can't contain itself", s.Name)
+	}
+
+	// the methods are all declared before any are checked, so they can call
+	// each other
+	type Method struct {
+		impl *parser.Node
+		node *parser.Node
+	}
+	var methods []Method
+	for _, impl := range tc.templates.impls[template.Value] {
+		bindings, err := Bind(impl, args)
+		if err != nil {
+			return Int, err
+		}
+		copy := Substitute(impl.Rhs, bindings)
+		for i := range copy.Stmts.Statements {
+			method := &copy.Stmts.Statements[i]
+			if _, field := s.Field(method.Value); field != nil {
+				return Int, fmt.Errorf("'%s' has a field and a method called '%s'", template.Value, method.Value)
+			}
+			method.Value = s.Name + "." + method.Value
+			if tc.FindFunction(method.Value) != nil {
+				return Int, fmt.Errorf("function '%s' already declared", method.Value)
+			}
+			fn, err := tc.Signature(method)
+			if err != nil {
+				return Int, err
+			}
+			tc.templates.instances[method.Value] = fn
+			methods = append(methods, Method{impl, method})
+		}
+	}
+	for _, method := range methods {
+		err := tc.CheckInstance(method.impl, method.node)
+		if err != nil {
+			return Int, err
+		}
+	}
+	return name, nil
+}
+
+// Infer matches the type of a parameter of a generic function against the type
+// of the argument it's given, binding any type parameters it uses that aren't
+// bound yet.
+func (tc *TypeChecker) Infer(param *parser.Node, ty VarType, bindings map[string]VarType) {
+	switch param.Type {
+	case parser.NodeSliceType:
+		if ty.IsSlice() {
+			tc.Infer(param.Lhs, ty.Elem(), bindings)
+		}
+	case parser.NodeMapType:
+		if ty.IsMap() {
+			tc.Infer(param.Lhs, ty.Key(), bindings)
+			tc.Infer(param.Rhs, ty.Value(), bindings)
+		}
+	case parser.NodeTypeName:
+		if bound, ok := bindings[param.Value]; ok && param.Lhs == nil {
+			if bound == "" {
+				bindings[param.Value] = ty
+			}
+			return
+		}
+		s := tc.FindStruct(string(ty))
+		if s == nil || s.Template != param.Value {
+			return
+		}
+		i := 0
+		for arg := param.Lhs; arg != nil && i < len(s.Args); arg = arg.Rhs {
+			tc.Infer(arg.Lhs, s.Args[i], bindings)
+			i++
+		}
+	}
+}
+
+// InstantiateFunction makes the instance of a generic function for a call,
+// inferring its type arguments from the arguments of the call, and returns
+// the name of the instance, as in max[int].
+func (tc *TypeChecker) InstantiateFunction(template *parser.Node, call *parser.Node) (string, error) {
+	bindings := map[string]VarType{}
+	for param := template.Lhs; param != nil; param = param.Rhs {
+		bindings[param.Lhs.Value] = ""
+	}
+	arg := call.Rhs
+	for param := template.Rhs.Lhs; param != nil && arg != nil; arg = arg.Rhs {
+		ty_node, value := param.Lhs.Lhs, arg.Lhs
+		if ty_node.Type == parser.NodeVariadicType {
+			// the rest of the arguments are elements, or a slice of them
+			ty_node = ty_node.Lhs
+			if value.Type == parser.NodeSpread {
+				ty_node, value = &parser.Node{Type: parser.NodeSliceType, Lhs: ty_node}, value.Lhs
+			}
+		} else {
+			param = param.Rhs
+		}
+		ty, err := tc.GetType(value)
+		if err != nil {
+			return "", err
+		}
+		tc.Infer(ty_node, *ty, bindings)
+	}
+
+	var args []VarType
+	for param := template.Lhs; param != nil; param = param.Rhs {
+		if bindings[param.Lhs.Value] == "" {
+			return "", fmt.Errorf("can't infer type parameter '%s' of '%s'", param.Lhs.Value, template.Value)
+		}
+		args = append(args, bindings[param.Lhs.Value])
+	}
+	name := string(Instance(template.Value, args))
+	if tc.FindFunction(name) != nil {
+		return name, nil
+	}
+	if tc.templates.depth >= max_instance_depth {
+		return "", fmt.Errorf("too many nested instances of '%s'", template.Value)
+	}
+	tc.templates.depth++
+	defer func() { tc.templates.depth-- }()
+
+	fn := Substitute(template.Rhs, bindings)
+	fn.Value = name
+	sig, err := tc.Signature(fn)
+	if err != nil {
+		return "", err
+	}
+	tc.templates.instances[name] = sig
+	return name, tc.CheckInstance(template, fn)
+}
+
+// CheckInstance checks the body of an instance of a generic function or
+// method, as if it were declared where its template is, so it sees the same
+// globals. Instances made before the checker reaches the template wait until
+// it does. Once checked the instance is added to the template, to be generated
+// along with it.
+func (tc *TypeChecker) CheckInstance(template *parser.Node, node *parser.Node) error {
+	globals := tc.templates.scopes[template]
+	if globals == nil {
+		tc.templates.pending[template] = append(tc.templates.pending[template], node)
+		return nil
+	}
+	checker := TypeChecker{scope: globals, functions: tc.functions, structs: tc.structs, interfaces: tc.interfaces, unions: tc.unions, templates: tc.templates}
+	err := checker.CheckFunction(node)
+	if err != nil && !strings.HasPrefix(err.Error(), "in ") {
+		// only the innermost instance, the one with the error, is named
+		return fmt.Errorf("in %s: %s", node.Value, err)
+	}
+	if err != nil {
+		return err
+	}
+	template.Stmts.Statements = append(template.Stmts.Statements, *node)
+	return nil
+}
+
+// ReachTemplate checks the instances of a generic function or method made
+// before the checker reached its declaration, now that the globals it can see
+// are known.
+func (tc *TypeChecker) ReachTemplate(node *parser.Node) error {
+	globals := scope.New[Variable](nil)
+	for _, name := range tc.scope.Names() {
+		globals.Declare(name, *tc.scope.Local(name))
+	}
+	tc.templates.scopes[node] = globals
+
+	pending := tc.templates.pending[node]
+	delete(tc.templates.pending, node)
+	for _, instance := range pending {
+		err := tc.CheckInstance(node, instance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains reports whether the zero value of a type has a value of the type
+// called name, either as a field of a struct or a value of the first variant
+// of a union, directly or in a type it contains.
+func (tc *TypeChecker) Contains(ty VarType, name string, seen map[string]bool) bool {
+	if seen[string(ty)] {
+		return false
+	}
+	seen[string(ty)] = true
+	var types []VarType
+	if s := tc.FindStruct(string(ty)); s != nil {
+		for _, field := range s.Fields {
+			types = append(types, field.Type)
+		}
+	} else if u := tc.FindUnion(string(ty)); u != nil && len(u.Variants) > 0 {
+		types = u.Variants[0].Types
+	}
+	for _, t := range types {
+		if string(t) == name || tc.Contains(t, name, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeclareMethods adds the methods of an impl block as functions named after
+// the type they belong to, as in Point.len.
+func (tc *TypeChecker) DeclareMethods(node *parser.Node) error {
+	s := tc.FindStruct(node.Value)
+	if s == nil {
+		return fmt.Errorf("methods declared for unknown type '%s'", node.Value)
+	}
+	for i := range node.Stmts.Statements {
+		method := &node.Stmts.Statements[i]
+		if _, field := s.Field(method.Value); field != nil {
+			return fmt.Errorf("'%s' has a field and a method called '%s'", s.Name, method.Value)
+		}
+		method.Value = s.Name + "." + method.Value
+		err := tc.DeclareFunction(method)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeclareInterface adds the methods of an interface, as functions taking the
+// interface as their receiver.
+func (tc *TypeChecker) DeclareInterface(node *parser.Node) error {
+	iface := tc.FindInterface(node.Value)
+	for i := range node.Stmts.Statements {
+		method := &node.Stmts.Statements[i]
+		if iface.Method(method.Value) != -1 {
+			return fmt.Errorf("method '%s' already declared in interface '%s'", method.Value, iface.Name)
+		}
+		iface.Methods = append(iface.Methods, method.Value)
+		method.Value = iface.Name + "." + method.Value
+		err := tc.DeclareFunction(method)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Implements checks that a struct has all the methods of an interface, with
+// the same signatures.
+func (tc *TypeChecker) Implements(ty VarType, iface *Interface) error {
+	if tc.FindStruct(string(ty)) == nil {
+		return fmt.Errorf("%s can't implement %s, only structs can", ty, iface.Name)
+	}
+	for _, name := range iface.Methods {
+		want := tc.FindFunction(iface.Name + "." + name)
+		method := tc.FindFunction(string(ty) + "." + name)
+		if method == nil {
+			return fmt.Errorf("%s doesn't implement %s, it has no method '%s'", ty, iface.Name, name)
+		}
+		same := method.Result == want.Result && method.Variadic == want.Variadic && slices.Equal(method.Params[1:], want.Params[1:]) && slices.Equal(method.Returns, want.Returns)
+		if !same {
+			return fmt.Errorf("%s doesn't implement %s, method '%s' has the wrong signature", ty, iface.Name, name)
+		}
+	}
+	return nil
+}
+
+// Convert checks that a value of type ty can be used where one of type to is
+// expected. A struct used as an interface it implements is converted to an
+// interface value, pointing to both the struct and its methods, and a value
+// used as an optional is wrapped in one.
+func (tc *TypeChecker) Convert(node *parser.Node, ty VarType, to VarType) bool {
+	if ty == to {
+		return true
+	}
+	if to.IsOptional() {
+		if ty == None {
+			return true
+		}
+		if !tc.Convert(node, ty, to.Unwrap()) {
+			return false
+		}
+		value := *node
+		*node = parser.Node{Type: parser.NodeSome, Lhs: &value, Line: node.Line, Col: node.Col}
+		return true
+	}
+	iface := tc.FindInterface(string(to))
+	if iface == nil || tc.Implements(ty, iface) != nil {
+		return false
+	}
+	var methods []*parser.Node
+	for _, name := range iface.Methods {
+		methods = append(methods, &parser.Node{Type: parser.NodeIdentifier, Value: string(ty) + "." + name})
+	}
+	value := *node
+	*node = parser.Node{Type: parser.NodeInterfaceValue, Value: iface.Name, Lhs: &value, Rhs: &parser.Node{Type: parser.NodeTypeName, Value: string(ty), Lhs: Params(methods...)}, Line: node.Line, Col: node.Col}
+	return true
+}
+
+// Unify finds the type that two values can both be converted to, converting
+// them. none and a value unify to an optional of the value, and a struct and
+// an interface it implements to the interface.
+func (tc *TypeChecker) Unify(a *parser.Node, aty VarType, b *parser.Node, bty VarType) (VarType, bool) {
+	candidates := []VarType{aty, bty}
+	if aty == None && bty != None && !bty.IsOptional() {
+		candidates = []VarType{OptionalOf(bty)}
+	} else if bty == None && aty != None && !aty.IsOptional() {
+		candidates = []VarType{OptionalOf(aty)}
+	}
+	for _, to := range candidates {
+		if tc.Convert(a, aty, to) && tc.Convert(b, bty, to) {
+			return to, true
+		}
+	}
+	return "", false
+}
+
+// DeclareFunction adds the signature of a function declaration, so that it
+// can be called from anywhere in the program.
+func (tc *TypeChecker) DeclareFunction(node *parser.Node) error {
+	if tc.FindFunction(node.Value) != nil || IsGeneric(node.Value) || tc.templates.functions[node.Value] != nil {
+		return fmt.Errorf("function '%s' already declared", node.Value)
+	}
+	fn, err := tc.Signature(node)
+	if err != nil {
+		return err
+	}
+	tc.functions = append(tc.functions, *fn)
+	return nil
+}
+
+// Signature returns the types of the parameters and results of a function
+// declaration.
+func (tc *TypeChecker) Signature(node *parser.Node) (*Function, error) {
+	fn := Function{Name: node.Value}
+	for param := node.Lhs; param != nil; param = param.Rhs {
+		if param.Lhs.Lhs == nil {
+			return nil, fmt.Errorf("self can only be used as the receiver of a method, in '%s'", node.Value)
+		}
+		ty, err := tc.ParseType(param.Lhs.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		fn.Params = append(fn.Params, ty)
+		fn.Variadic = param.Lhs.Lhs.Type == parser.NodeVariadicType
+	}
+	if node.Rhs != nil && node.Rhs.Rhs == nil && node.Rhs.Lhs.Value == "Result" {
+		// a Result[T] is returned as the value followed by the error
+		if node.Rhs.Lhs.Lhs == nil || node.Rhs.Lhs.Lhs.Rhs != nil {
+			return nil, fmt.Errorf("expected Result[T] in declaration of '%s'", node.Value)
+		}
+		ty, err := tc.ParseType(node.Rhs.Lhs.Lhs.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if ty == Error {
+			return nil, fmt.Errorf("Result[error] is not allowed in declaration of '%s'", node.Value)
+		}
+		fn.Returns = []VarType{ty, Error}
+		fn.Result = true
+	} else {
+		for result := node.Rhs; result != nil; result = result.Rhs {
+			ty, err := tc.ParseType(result.Lhs)
+			if err != nil {
+				return nil, err
+			}
+			fn.Returns = append(fn.Returns, ty)
+		}
+	}
+	err := fn.CheckRegisters()
+	if err != nil {
+		return nil, err
+	}
+	return &fn, nil
+}
+
+// MaxValues is the most parameters a function can take, or values it can
+// return, as they're all passed in registers.
+const MaxValues = 6
+
+// CheckRegisters checks that the parameters and results of a function fit in
+// the registers they're passed in.
+func (fn *Function) CheckRegisters() error {
+	if len(fn.Params) > MaxValues {
+		return fmt.Errorf("'%s' has %d parameters, at most %d are allowed", fn.Name, len(fn.Params), MaxValues)
+	}
+	if len(fn.Returns) > MaxValues {
+		return fmt.Errorf("'%s' returns %d values, at most %d are allowed", fn.Name, len(fn.Returns), MaxValues)
+	}
+	return nil
+}
+
+// Built in functions whose signature depends on the type of their first
+// argument. Indexing maps is lowered to calls to the map_ functions.
+var generics = []string{"len", "append", "delete", "keys", "map_get", "map_lookup", "map_set"}
+
+func IsGeneric(name string) bool {
+	for _, generic := range generics {
+		if generic == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *TypeChecker) GetGenericFunction(node *parser.Node) (*Function, error) {
+	if node.Rhs == nil {
+		return nil, fmt.Errorf("not enough arguments in call to '%s'", node.Value)
+	}
+	first, err := tc.GetType(node.Rhs.Lhs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Value {
+	case "len":
+		if *first == String {
+			// strings have a length function of their own
+			node.Value = "strlen"
+			return tc.FindFunction(node.Value), nil
+		}
+		if !first.IsSlice() && !first.IsMap() {
+			return nil, fmt.Errorf("len expects a slice, a map or a string, got %s", *first)
+		}
+		return &Function{Name: "len", Params: []VarType{*first}, Returns: []VarType{Int}}, nil
+	case "append":
+		if !first.IsSlice() {
+			return nil, fmt.Errorf("append expects a slice, got %s", *first)
+		}
+		return &Function{Name: "append", Params: []VarType{*first, first.Elem()}, Returns: []VarType{*first}}, nil
+	}
+
+	if !first.IsMap() {
+		return nil, fmt.Errorf("%s expects a map, got %s", node.Value, *first)
+	}
+	switch node.Value {
+	case "delete":
+		return &Function{Name: "delete", Params: []VarType{*first, first.Key()}}, nil
+	case "keys":
+		return &Function{Name: "keys", Params: []VarType{*first}, Returns: []VarType{SliceOf(first.Key())}}, nil
+	case "map_get":
+		return &Function{Name: "map_get", Params: []VarType{*first, first.Key(), first.Value()}, Returns: []VarType{first.Value()}}, nil
+	case "map_lookup":
+		return &Function{Name: "map_lookup", Params: []VarType{*first, first.Key(), first.Value()}, Returns: []VarType{first.Value(), Int}}, nil
+	case "map_set":
+		return &Function{Name: "map_set", Params: []VarType{*first, first.Key(), first.Value()}}, nil
+	}
+	return nil, fmt.Errorf("call to undefined function '%s'", node.Value)
+}
+
+// CheckCall checks the arguments of a call against the signature of the
+// function being called.
+func (tc *TypeChecker) CheckCall(node *parser.Node) (*Function, error) {
+	if template := tc.templates.functions[node.Value]; template != nil {
+		name, err := tc.InstantiateFunction(template, node)
+		if err != nil {
+			return nil, err
+		}
+		node.Value = name
+	}
+
+	var fn *Function
+	if IsGeneric(node.Value) {
+		generic, err := tc.GetGenericFunction(node)
+		if err != nil {
+			return nil, err
+		}
+		fn = generic
+	} else {
+		fn = tc.FindFunction(node.Value)
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("call to undefined function '%s'", node.Value)
+	}
+	if err := fn.CheckRegisters(); err != nil {
+		return nil, err
+	}
+
+	i := 0
+	args := &node.Rhs
+	for ; *args != nil; args = &(*args).Rhs {
+		param := *args
+		if fn.Variadic && i == len(fn.Params)-1 {
+			err := tc.CheckVarArgs(fn, param)
+			if err != nil {
+				return nil, err
+			}
+			return fn, nil
+		}
+		if i >= len(fn.Params) {
+			if i >= MaxValues {
+				return nil, fmt.Errorf("too many arguments in call to '%s', at most %d are allowed", fn.Name, MaxValues)
+			}
+			return nil, fmt.Errorf("too many arguments in call to '%s'", fn.Name)
+		}
+		if param.Lhs.Type == parser.NodeSpread {
+			return nil, fmt.Errorf("%s can only be passed to a variadic parameter", parser.Format(param.Lhs))
+		}
+		arg, err := tc.GetType(param.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if !tc.Convert(param.Lhs, *arg, fn.Params[i]) {
+			if iface := tc.FindInterface(string(fn.Params[i])); iface != nil {
+				return nil, fmt.Errorf("argument %d of '%s' has the wrong type, %s", i+1, fn.Name, tc.Implements(*arg, iface))
+			}
+			return nil, fmt.Errorf("argument %d of '%s' has the wrong type", i+1, fn.Name)
+		}
+		i++
+	}
+	if fn.Variadic && i == len(fn.Params)-1 {
+		// no variadic arguments, so an empty slice
+		*args = &parser.Node{Type: parser.NodeParam, Lhs: &parser.Node{Type: parser.NodeVarArgs}}
+		return fn, nil
+	}
+	if i < len(fn.Params) {
+		return nil, fmt.Errorf("not enough arguments in call to '%s'", fn.Name)
+	}
+	return fn, nil
+}
+
+// CheckVarArgs checks the arguments of a call from the variadic parameter on,
+// which are packed together into a single argument for the generator to make
+// a slice of. A slice can be passed on as the arguments instead, with xs...
+func (tc *TypeChecker) CheckVarArgs(fn *Function, param *parser.Node) error {
+	slice := fn.Params[len(fn.Params)-1]
+	if param.Lhs.Type == parser.NodeSpread {
+		if param.Rhs != nil {
+			return fmt.Errorf("%s must be the last argument of '%s'", parser.Format(param.Lhs), fn.Name)
+		}
+		ty, err := tc.GetType(param.Lhs.Lhs)
+		if err != nil {
+			return err
+		}
+		if *ty != slice {
+			return fmt.Errorf("can't pass %s as the variadic arguments of '%s', expected %s", *ty, fn.Name, slice)
+		}
+		return nil
+	}
+	if param.Lhs.Type != parser.NodeVarArgs {
+		values := &parser.Node{Type: parser.NodeParam, Lhs: param.Lhs, Rhs: param.Rhs}
+		param.Lhs = &parser.Node{Type: parser.NodeVarArgs, Rhs: values}
+		param.Rhs = nil
+	}
+	i := len(fn.Params)
+	for value := param.Lhs.Rhs; value != nil; value = value.Rhs {
+		if value.Lhs.Type == parser.NodeSpread {
+			return fmt.Errorf("%s can't be passed along with other variadic arguments", parser.Format(value.Lhs))
+		}
+		ty, err := tc.GetType(value.Lhs)
+		if err != nil {
+			return err
+		}
+		if !tc.Convert(value.Lhs, *ty, slice.Elem()) {
+			return fmt.Errorf("argument %d of '%s' has the wrong type", i, fn.Name)
+		}
+		i++
+	}
+	return nil
+}
+
+func (tc *TypeChecker) CheckFunction(node *parser.Node) error {
+	if tc.nested {
+		return fmt.Errorf("function '%s' must be declared at the top level", node.Value)
+	}
+
+	// function bodies can see their own parameters and the globals declared
+	// before them, the parameters are in the same scope as the body
+	body := TypeChecker{scope: scope.New(tc.scope), functions: tc.functions, structs: tc.structs, interfaces: tc.interfaces, unions: tc.unions, templates: tc.templates, function: tc.FindFunction(node.Value), nested: true}
+	i := 0
+	for param := node.Lhs; param != nil; param = param.Rhs {
+		err := body.Declare(Variable{Name: param.Lhs.Value, Type: body.function.Params[i], Mutable: true, Line: param.Lhs.Line, Col: param.Lhs.Col})
+		if err != nil {
+			return err
+		}
+		i++
+	}
+
+	err := body.TypeCheck(node.Stmts)
+	if err != nil {
+		return err
+	}
+
+	last := node.Stmts.Statements[len(node.Stmts.Statements)-1]
+	if len(body.function.Returns) > 0 && last.Type != parser.NodeReturn {
+		return fmt.Errorf("missing return at end of function '%s'", node.Value)
+	}
+	return nil
+}
+
+func (tc *TypeChecker) CheckReturn(node *parser.Node) error {
+	if tc.function == nil {
+		return fmt.Errorf("return outside of a function")
+	}
+
+	if tc.function.Result && node.Lhs != nil && node.Lhs.Rhs == nil {
+		// returning a single value from a function returning a Result is
+		// either a success or an error, depending on the type of the value
+		ty, err := tc.GetType(node.Lhs.Lhs)
+		if err != nil {
+			return err
+		}
+		none := &parser.Node{Type: parser.NodeIntLiteral, Value: "0"}
+		if *ty == Error {
+			node.Lhs = &parser.Node{Type: parser.NodeParam, Lhs: none, Rhs: node.Lhs}
+		} else if tc.Convert(node.Lhs.Lhs, *ty, tc.function.Returns[0]) {
+			node.Lhs.Rhs = &parser.Node{Type: parser.NodeParam, Lhs: none}
+		} else {
+			return fmt.Errorf("return value of '%s' has the wrong type", tc.function.Name)
+		}
+		return nil
+	}
+
+	i := 0
+	for param := node.Lhs; param != nil; param = param.Rhs {
+		if i >= len(tc.function.Returns) {
+			return fmt.Errorf("too many values returned from '%s'", tc.function.Name)
+		}
+		ty, err := tc.GetType(param.Lhs)
+		if err != nil {
+			return err
+		}
+		if !tc.Convert(param.Lhs, *ty, tc.function.Returns[i]) {
+			return fmt.Errorf("return value %d of '%s' has the wrong type", i+1, tc.function.Name)
+		}
+		i++
+	}
+	if i < len(tc.function.Returns) {
+		return fmt.Errorf("not enough values returned from '%s'", tc.function.Name)
+	}
+	return nil
+}
+
+func (tc *TypeChecker) GetType(node *parser.Node) (*VarType, error) {
+	ty := Int
+	if node.Type == parser.NodeStringLiteral {
+		ty = String
+	} else if node.Type == parser.NodeIdentifier {
+		v := tc.FindVariable(node.Value)
+		if v == nil {
+			return nil, fmt.Errorf("variable '%s' not in scope", node.Value)
+		}
+		ty = v.Type
+	} else if node.Type == parser.NodeAdd {
+		lhs, err := tc.GetType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if tc.Overloadable(*lhs) {
+			err := tc.LowerOperator(node)
+			if err != nil {
+				return nil, err
+			}
+			return tc.GetType(node)
+		}
+		rhs, err := tc.GetType(node.Rhs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ty := range []VarType{*lhs, *rhs} {
+			if ty.IsOptional() || ty == None {
+				return nil, Unwrapped(ty)
+			}
+		}
+		if *lhs != *rhs {
+			return nil, fmt.Errorf("can't add variables of differing types")
+		}
+		if *lhs != Int {
+			return nil, NotArithmetic(node, *lhs)
+		}
+	} else if node.Type == parser.NodeSub || node.Type == parser.NodeMulti || node.Type == parser.NodeDiv || node.Type == parser.NodeMod {
+		for _, operand := range []*parser.Node{node.Lhs, node.Rhs} {
+			ty, err := tc.GetType(operand)
+			if err != nil {
+				return nil, err
+			}
+			if operand == node.Lhs && tc.Overloadable(*ty) {
+				err := tc.LowerOperator(node)
+				if err != nil {
+					return nil, err
+				}
+				return tc.GetType(node)
+			}
+			if ty.IsOptional() || *ty == None {
+				return nil, Unwrapped(*ty)
+			}
+			if *ty != Int {
+				return nil, NotArithmetic(node, *ty)
+			}
+		}
+	} else if node.Type == parser.NodeNone {
+		ty = None
+	} else if node.Type == parser.NodeSome {
+		value, err := tc.GetType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		ty = OptionalOf(*value)
+	} else if node.Type == parser.NodeSliceLiteral {
+		slice, err := tc.ParseType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		for elem := node.Rhs; elem != nil; elem = elem.Rhs {
+			ty, err := tc.GetType(elem.Lhs)
+			if err != nil {
+				return nil, err
+			}
+			if !tc.Convert(elem.Lhs, *ty, slice.Elem()) {
+				return nil, fmt.Errorf("can't use %s as an element of %s", *ty, slice)
+			}
+		}
+		ty = slice
+	} else if node.Type == parser.NodeMapLiteral {
+		m, err := tc.ParseType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		for entry := node.Rhs; entry != nil; entry = entry.Rhs {
+			key, err := tc.GetType(entry.Lhs.Lhs)
+			if err != nil {
+				return nil, err
+			}
+			if *key != m.Key() {
+				return nil, fmt.Errorf("can't use %s as a key of %s", *key, m)
+			}
+			value, err := tc.GetType(entry.Lhs.Rhs)
+			if err != nil {
+				return nil, err
+			}
+			if !tc.Convert(entry.Lhs.Rhs, *value, m.Value()) {
+				return nil, fmt.Errorf("can't use %s as a value of %s", *value, m)
+			}
+		}
+		ty = m
+	} else if node.Type == parser.NodeIndex {
+		slice, err := tc.GetType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if slice.IsMap() {
+			err := tc.LowerMapIndex(node, *slice)
+			if err != nil {
+				return nil, err
+			}
+			return tc.GetType(node)
+		}
+		if !slice.IsSlice() {
+			return nil, fmt.Errorf("can't index %s", *slice)
+		}
+		index, err := tc.GetType(node.Rhs)
+		if err != nil {
+			return nil, err
+		}
+		if *index != Int {
+			return nil, fmt.Errorf("index must be an int, got %s", *index)
+		}
+		ty = slice.Elem()
+	} else if node.Type == parser.NodeSlice {
+		slice, err := tc.GetType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if !slice.IsSlice() {
+			return nil, fmt.Errorf("can't slice %s", *slice)
+		}
+		for _, bound := range []*parser.Node{node.Rhs.Lhs, node.Rhs.Rhs} {
+			if bound == nil {
+				continue
+			}
+			index, err := tc.GetType(bound)
+			if err != nil {
+				return nil, err
+			}
+			if *index != Int {
+				return nil, fmt.Errorf("index must be an int, got %s", *index)
+			}
+		}
+		ty = *slice
+	} else if node.Type == parser.NodeStructLiteral {
+		err := tc.CheckStructLiteral(node)
+		if err != nil {
+			return nil, err
+		}
+		ty = VarType(node.Value)
+	} else if node.Type == parser.NodeField && tc.IsUnionName(node.Lhs) {
+		err := tc.LowerVariant(node, nil)
+		if err != nil {
+			return nil, err
+		}
+		ty = VarType(node.Value)
+	} else if node.Type == parser.NodeUnionValue {
+		ty = VarType(node.Value)
+	} else if node.Type == parser.NodeSpread {
+		return nil, fmt.Errorf("%s can only be passed to a variadic parameter", parser.Format(node))
+	} else if node.Type == parser.NodeField {
+		target, err := tc.GetType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		s := tc.FindStruct(string(*target))
+		if s == nil {
+			return nil, fmt.Errorf("%s has no fields", *target)
+		}
+		i, field := s.Field(node.Value)
+		if field == nil {
+			return nil, fmt.Errorf("%s has no field '%s'", s.Name, node.Value)
+		}
+		// the generator only needs to know where the field is
+		node.Rhs = &parser.Node{Type: parser.NodeIntLiteral, Value: fmt.Sprint(i)}
+		ty = field.Type
+	} else if node.Type == parser.NodeMethodCall {
+		err := tc.LowerMethodCall(node)
+		if err != nil {
+			return nil, err
+		}
+		return tc.GetType(node)
+	} else if node.Type == parser.NodeIfExpr {
+		then, err := tc.GetType(node.Rhs.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := tc.GetType(node.Rhs.Rhs)
+		if err != nil {
+			return nil, err
+		}
+		unified, ok := tc.Unify(node.Rhs.Lhs, *then, node.Rhs.Rhs, *otherwise)
+		if !ok {
+			return nil, fmt.Errorf("branches of if have different types, %s and %s", *then, *otherwise)
+		}
+		ty = unified
+	} else if node.Type == parser.NodeInterpolate {
+		for part := node.Lhs; part != nil; part = part.Rhs {
+			err := tc.Stringify(part)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ty = String
+	} else if node.Type == parser.NodeInterfaceValue {
+		ty = VarType(node.Value)
+	} else if node.Type == parser.NodeCall || node.Type == parser.NodeDynamicCall {
+		fn, err := tc.CheckCall(node)
+		if err != nil {
+			return nil, err
+		}
+		if fn.Result {
+			return nil, fmt.Errorf("the result of '%s' must be handled with try, or assigned to a value and an error", fn.Name)
+		}
+		if len(fn.Returns) != 1 {
+			return nil, fmt.Errorf("'%s' returns %d values, expected 1", fn.Name, len(fn.Returns))
+		}
+		ty = fn.Returns[0]
+	} else if node.Type == parser.NodeTry {
+		if node.Lhs.Type == parser.NodeMethodCall {
+			err := tc.LowerMethodCall(node.Lhs)
+			if err != nil {
+				return nil, err
+			}
+			if node.Lhs.Type != parser.NodeCall && node.Lhs.Type != parser.NodeDynamicCall {
+				return nil, fmt.Errorf("try used on a variant of %s, which isn't a call", node.Lhs.Value)
+			}
+		}
+		fn, err := tc.CheckCall(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if !fn.Result {
+			return nil, fmt.Errorf("try used on '%s', which doesn't return a Result", fn.Name)
+		}
+		if tc.function != nil && !tc.function.Result {
+			return nil, fmt.Errorf("try used in '%s', which doesn't return a Result", tc.function.Name)
+		}
+		ty = fn.Returns[0]
+	}
+
+	return &ty, nil
+}
+
+// NotArithmetic reports an arithmetic operator used on a value that isn't an
+// int, once any overloading of it has been ruled out.
+func NotArithmetic(node *parser.Node, ty VarType) error {
+	return fmt.Errorf("'%s' can only be used on ints, got %s", arithmetic_operators[node.Type], ty)
+}
+
+// the operators of arithmetic, for messages
+var arithmetic_operators = map[parser.NodeType]string{
+	parser.NodeAdd:   "+",
+	parser.NodeSub:   "-",
+	parser.NodeMulti: "*",
+	parser.NodeDiv:   "/",
+	parser.NodeMod:   "%",
+}
+
+// Unwrapped is the error for using an optional as if it were its value.
+func Unwrapped(ty VarType) error {
+	if ty == None {
+		return fmt.Errorf("none can only be used as an optional")
+	}
+	return fmt.Errorf("%s must be unwrapped with if let or match before it's used", ty)
+}
+
+// operator_methods are the names of the methods that overload operators for a
+// struct. a > b is b.lt(a).
+var operator_methods = map[parser.NodeType]string{
+	parser.NodeAdd:   "add",
+	parser.NodeSub:   "sub",
+	parser.NodeMulti: "mul",
+	parser.NodeDiv:   "div",
+	parser.NodeMod:   "rem",
+	parser.NodeEq:    "eq",
+	parser.NodeLt:    "lt",
+	parser.NodeGt:    "lt",
+}
+
+// Overloadable reports whether operators used on a value of a type are calls
+// to its methods, which they are for structs and interfaces.
+func (tc *TypeChecker) Overloadable(ty VarType) bool {
+	return tc.FindStruct(string(ty)) != nil || tc.FindInterface(string(ty)) != nil
+}
+
+// HasMethod reports whether a struct or interface has a method.
+func (tc *TypeChecker) HasMethod(ty VarType, name string) bool {
+	if iface := tc.FindInterface(string(ty)); iface != nil {
+		return iface.Method(name) != -1
+	}
+	return tc.FindFunction(string(ty)+"."+name) != nil
+}
+
+// LowerOperator rewrites an operator used on a struct or interface into a call
+// to the method overloading it, so a + b is a.add(b). The methods overloading
+// comparisons return an int, which is true when it's above zero, so a < b is
+// a.lt(b) > 0.
+func (tc *TypeChecker) LowerOperator(node *parser.Node) error {
+	name := operator_methods[node.Type]
+	receiver, arg := node.Lhs, node.Rhs
+	if node.Type == parser.NodeGt {
+		receiver, arg = arg, receiver
+	}
+	ty, err := tc.GetType(receiver)
+	if err != nil {
+		return err
+	}
+	if !tc.HasMethod(*ty, name) {
+		return fmt.Errorf("%s has no method '%s' to overload the operator with", *ty, name)
+	}
+
+	call := &parser.Node{Type: parser.NodeMethodCall, Value: name, Lhs: receiver, Rhs: Params(arg), Line: node.Line, Col: node.Col}
+	err = tc.LowerMethodCall(call)
+	if err != nil {
+		return err
+	}
+	result, err := tc.GetType(call)
+	if err != nil {
+		return err
+	}
+	if node.Type == parser.NodeEq || node.Type == parser.NodeLt || node.Type == parser.NodeGt {
+		if *result != Int {
+			return fmt.Errorf("%s.%s must return an int to overload a comparison, got %s", *ty, name, *result)
+		}
+		*node = parser.Node{Type: parser.NodeGt, Lhs: call, Rhs: &parser.Node{Type: parser.NodeIntLiteral, Value: "0"}, Line: node.Line, Col: node.Col}
+		return nil
+	}
+	*node = *call
+	return nil
+}
+
+// CheckComparison checks that the values compared by a test aren't optionals,
+// except for comparing an optional with none to see if it's empty. Comparing
+// structs and interfaces calls the methods overloading the comparison, though
+// those without an eq method are only equal to themselves.
+func (tc *TypeChecker) CheckComparison(node *parser.Node) error {
+	lhs, err := tc.GetType(node.Lhs)
+	if err != nil {
+		return err
+	}
+	rhs, err := tc.GetType(node.Rhs)
+	if err != nil {
+		return err
+	}
+	receiver := *lhs
+	if node.Type == parser.NodeGt {
+		receiver = *rhs
+	}
+	if tc.Overloadable(receiver) && (node.Type != parser.NodeEq || tc.HasMethod(receiver, "eq")) {
+		return tc.LowerOperator(node)
+	}
+	if node.Type == parser.NodeEq && (*lhs == None && rhs.IsOptional() || lhs.IsOptional() && *rhs == None) {
+		return nil
+	}
+	for _, ty := range []VarType{*lhs, *rhs} {
+		if ty.IsOptional() || ty == None {
+			return Unwrapped(ty)
+		}
+	}
+	return nil
+}
+
+// CheckIfLet checks an if let, which declares the value of an optional in the
+// scope of its body when there is one.
+func (tc *TypeChecker) CheckIfLet(node *parser.Node) error {
+	ty, err := tc.GetType(node.Lhs.Rhs)
+	if err != nil {
+		return err
+	}
+	if !ty.IsOptional() {
+		return fmt.Errorf("if let expects an optional, got %s", *ty)
+	}
+	body := tc.Block()
+	err = body.DeclareLet(node.Lhs, node.Lhs.Lhs, ty.Unwrap())
+	if err != nil {
+		return err
+	}
+	err = body.TypeCheck(node.Stmts)
+	if err != nil {
+		return err
+	}
+	_, err = tc.CheckNode(node.Rhs)
+	return err
+}
+
+// Variants returns the variants a value of a type can be matched against, or
+// nil if it has none. An optional has two, Some with its value and none.
+func (tc *TypeChecker) Variants(ty VarType) []Variant {
+	if ty.IsOptional() {
+		return []Variant{{Name: "Some", Types: []VarType{ty.Unwrap()}}, {Name: "none"}}
+	}
+	if u := tc.FindUnion(string(ty)); u != nil {
+		return u.Variants
+	}
+	return nil
+}
+
+// Constructors returns the different shapes of value a type has, for working
+// out which of them a set of patterns matches. Those are the variants of
+// optionals and unions, and the fields of a struct, while ints and strings
+// have too many to list, so are nil. Each int pattern is a constructor of
+// its own.
+func (tc *TypeChecker) Constructors(ty VarType) []Variant {
+	if s := tc.FindStruct(string(ty)); s != nil {
+		fields := Variant{Name: s.Name}
+		for _, field := range s.Fields {
+			fields.Types = append(fields.Types, field.Type)
+		}
+		return []Variant{fields}
+	}
+	return tc.Variants(ty)
+}
+
+// Pattern is the shape of the values a pattern matches, the constructor it
+// matches and patterns for each of the constructor's values, or a wildcard
+// matching anything when the constructor is empty.
+type Pattern struct {
+	Constructor string
+	Args        []*Pattern
+}
+
+func Wildcards(n int) []*Pattern {
+	wildcards := make([]*Pattern, n)
+	for i := range wildcards {
+		wildcards[i] = &Pattern{}
+	}
+	return wildcards
+}
+
+// CheckPattern checks that a pattern can match a value of type ty, declaring
+// the variables it binds, and returns its shape. The patterns of variants of
+// a union are given the index of the variant and those of struct fields the
+// index of the field, for the generator.
+func (tc *TypeChecker) CheckPattern(node *parser.Node, ty VarType, mutable bool) (*Pattern, error) {
+	variants := tc.Variants(ty)
+	index := func(name string) int {
+		for i := range variants {
+			if variants[i].Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+	if node.Type == parser.NodeIdentifier && index(node.Value) != -1 {
+		// a variant without values, as in Eof
+		node.Type = parser.NodeVariant
+	}
+
+	switch node.Type {
+	case parser.NodeIdentifier:
+		if node.Value != "_" {
+			err := tc.Declare(Variable{Name: node.Value, Type: ty, Mutable: mutable, Line: node.Line, Col: node.Col})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &Pattern{}, nil
+	case parser.NodeNone, parser.NodeVariant:
+		name := node.Value
+		if node.Type == parser.NodeNone {
+			name = "none"
+		}
+		i := index(name)
+		if i == -1 {
+			return nil, fmt.Errorf("%s has no variant '%s'", ty, name)
+		}
+		var args []*Pattern
+		for value := node.Lhs; value != nil; value = value.Rhs {
+			if len(args) == len(variants[i].Types) {
+				args = append(args, nil)
+				continue
+			}
+			arg, err := tc.CheckPattern(value.Lhs, variants[i].Types[len(args)], mutable)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		if len(args) != len(variants[i].Types) {
+			return nil, fmt.Errorf("%s has %d values, got %d", name, len(variants[i].Types), len(args))
+		}
+		if !ty.IsOptional() {
+			node.Rhs = &parser.Node{Type: parser.NodeIntLiteral, Value: fmt.Sprint(i)}
+		}
+		return &Pattern{Constructor: name, Args: args}, nil
+	case parser.NodeStructPattern:
+		s := tc.FindStruct(string(ty))
+		if s == nil || s.Name != node.Value && s.Template != node.Value {
+			return nil, fmt.Errorf("can't match %s with a %s pattern", ty, node.Value)
+		}
+		args := Wildcards(len(s.Fields))
+		seen := map[string]bool{}
+		for field := node.Rhs; field != nil; field = field.Rhs {
+			i, f := s.Field(field.Lhs.Value)
+			if f == nil {
+				return nil, fmt.Errorf("%s has no field '%s'", s.Name, field.Lhs.Value)
+			}
+			if seen[f.Name] {
+				return nil, fmt.Errorf("field '%s' matched twice", f.Name)
+			}
+			seen[f.Name] = true
+			field.Lhs.Rhs = &parser.Node{Type: parser.NodeIntLiteral, Value: fmt.Sprint(i)}
+			arg, err := tc.CheckPattern(field.Lhs.Lhs, f.Type, mutable)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = arg
+		}
+		return &Pattern{Constructor: s.Name, Args: args}, nil
+	case parser.NodeIntLiteral:
+		if ty != Int {
+			return nil, fmt.Errorf("can't match %s with %s", ty, node.Value)
+		}
+		return &Pattern{Constructor: node.Value}, nil
+	}
+	return nil, fmt.Errorf("pattern can't match %s", ty)
+}
+
+// Useful reports whether a row of patterns, one for each of a list of values,
+// matches any values that none of the rows before it do, and returns an
+// example of them if it does. This is the usefulness check from Maranget's
+// "Warnings for pattern matching", a match covers every value when a
+// wildcard isn't useful after its arms.
+func (tc *TypeChecker) Useful(rows [][]*Pattern, row []*Pattern, types []VarType) ([]string, bool) {
+	if len(row) == 0 {
+		return nil, len(rows) == 0
+	}
+	constructors := tc.Constructors(types[0])
+	if row[0].Constructor != "" {
+		for _, c := range constructors {
+			if c.Name == row[0].Constructor {
+				return tc.UsefulFor(c, rows, row, types)
+			}
+		}
+		// an int, one of too many to list
+		return tc.UsefulFor(Variant{Name: row[0].Constructor}, rows, row, types)
+	}
+
+	// a wildcard is useful for a constructor that every row doesn't match,
+	// which it can only be checked for once every constructor is used
+	seen := map[string]bool{}
+	for _, r := range rows {
+		if r[0].Constructor != "" {
+			seen[r[0].Constructor] = true
+		}
+	}
+	if constructors != nil && len(seen) == len(constructors) {
+		for _, c := range constructors {
+			if example, ok := tc.UsefulFor(c, rows, row, types); ok {
+				return example, true
+			}
+		}
+		return nil, false
+	}
+	var rest [][]*Pattern
+	for _, r := range rows {
+		if r[0].Constructor == "" {
+			rest = append(rest, r[1:])
+		}
+	}
+	example, ok := tc.Useful(rest, row[1:], types[1:])
+	if !ok {
+		return nil, false
+	}
+	head := "_"
+	for _, c := range constructors {
+		if len(seen) > 0 && !seen[c.Name] {
+			head = tc.FormatPattern(types[0], c, make([]string, len(c.Types)))
+			break
+		}
+	}
+	if types[0] == Int && len(seen) > 0 {
+		n := 0
+		for seen[fmt.Sprint(n)] {
+			n++
+		}
+		head = fmt.Sprint(n)
+	}
+	return append([]string{head}, example...), true
+}
+
+// UsefulFor is Useful for the values of a row made with one constructor,
+// which are matched by the rows with the same constructor or a wildcard.
+func (tc *TypeChecker) UsefulFor(c Variant, rows [][]*Pattern, row []*Pattern, types []VarType) ([]string, bool) {
+	n := len(c.Types)
+	specialize := func(r []*Pattern) []*Pattern {
+		args := r[0].Args
+		if r[0].Constructor == "" {
+			args = Wildcards(n)
+		}
+		return append(append([]*Pattern{}, args...), r[1:]...)
+	}
+	var specialized [][]*Pattern
+	for _, r := range rows {
+		if r[0].Constructor == "" || r[0].Constructor == c.Name {
+			specialized = append(specialized, specialize(r))
+		}
+	}
+	example, ok := tc.Useful(specialized, specialize(row), append(append([]VarType{}, c.Types...), types[1:]...))
+	if !ok {
+		return nil, false
+	}
+	return append([]string{tc.FormatPattern(types[0], c, example[:n])}, example[n:]...), true
+}
+
+// FormatPattern renders a constructor with examples of its values, for use in
+// messages. Values without an example are left as _.
+func (tc *TypeChecker) FormatPattern(ty VarType, c Variant, args []string) string {
+	for i := range args {
+		if args[i] == "" {
+			args[i] = "_"
+		}
+	}
+	if s := tc.FindStruct(string(ty)); s != nil {
+		var fields []string
+		for i, arg := range args {
+			if arg != "_" {
+				fields = append(fields, s.Fields[i].Name+": "+arg)
+			}
+		}
+		return s.Name + "{" + strings.Join(fields, ", ") + "}"
+	}
+	if len(args) == 0 {
+		return c.Name
+	}
+	return c.Name + "(" + strings.Join(args, ", ") + ")"
 }
 
-type TypeChecker struct {
-	variables []Variable
+// CheckMatch checks the arms of a match against the type of the value being
+// matched. Every value must be matched by one of the arms, and each arm must
+// match something the arms before it don't. Arms with guards aren't counted
+// as matching anything for either, as their guards may not hold.
+func (tc *TypeChecker) CheckMatch(node *parser.Node) error {
+	ty, err := tc.GetType(node.Lhs)
+	if err != nil {
+		return err
+	}
+	types := []VarType{*ty}
+
+	var rows [][]*Pattern
+	for arm := node.Rhs; arm != nil; arm = arm.Rhs {
+		body := tc.Block()
+		pattern, err := body.CheckPattern(arm.Lhs.Lhs, *ty, false)
+		if err != nil {
+			return err
+		}
+		if _, ok := tc.Useful(rows, []*Pattern{pattern}, types); !ok {
+			return fmt.Errorf("unreachable arm in match at line %d, col %d", arm.Lhs.Line, arm.Lhs.Col)
+		}
+		if arm.Lhs.Rhs != nil {
+			_, err := body.CheckNode(arm.Lhs.Rhs)
+			if err != nil {
+				return err
+			}
+		} else {
+			rows = append(rows, []*Pattern{pattern})
+		}
+		err = body.TypeCheck(arm.Lhs.Stmts)
+		if err != nil {
+			return err
+		}
+	}
+	if example, ok := tc.Useful(rows, Wildcards(1), types); ok {
+		return fmt.Errorf("match of %s doesn't handle %s", *ty, example[0])
+	}
+	return nil
 }
 
-func (tc *TypeChecker) GetType(node *parser.Node) (*VarType, error) {
-	ty := Int
-	if node.Type == parser.NodeStringLiteral {
-		ty = String
-	} else if node.Type == parser.NodeIdentifier {
-		for _, v := range tc.variables {
-			if v.Name == node.Value {
-				ty = v.Type
-				return &ty, nil
+// CheckDestructure checks a let with a pattern, which must match every value
+// of the type it's given.
+func (tc *TypeChecker) CheckDestructure(node *parser.Node) error {
+	ty, err := tc.GetType(node.Rhs)
+	if err != nil {
+		return err
+	}
+	pattern, err := tc.CheckPattern(node.Lhs, *ty, node.Value == "var")
+	if err != nil {
+		return err
+	}
+	if example, ok := tc.Useful([][]*Pattern{{pattern}}, Wildcards(1), []VarType{*ty}); ok {
+		return fmt.Errorf("pattern in %s doesn't match every %s, such as %s", node.Value, *ty, example[0])
+	}
+	return nil
+}
+
+// Method returns the signature of a method of a struct or interface, or nil if
+// it has no such method.
+func (tc *TypeChecker) Method(ty VarType, name string) *Function {
+	if !tc.Overloadable(ty) {
+		return nil
+	}
+	return tc.FindFunction(string(ty) + "." + name)
+}
+
+// LowerForIn rewrites a for in loop into a scope declaring hidden variables
+// for the collection and the position in it, and a for loop declaring the
+// targets from them before running the body in a scope of its own. Slices,
+// and maps by their keys, are iterated by index, and strings byte by byte up
+// to their terminating zero. Anything else is iterated by calling its next
+// method until it returns none, or that of the value returned by its iter
+// method if it has one, so that iterating over it doesn't use it up.
+func (tc *TypeChecker) LowerForIn(node *parser.Node) error {
+	ty, err := tc.GetType(node.Rhs)
+	if err != nil {
+		return err
+	}
+	var targets []*parser.Node
+	for target := node.Lhs.Lhs; target != nil; target = target.Rhs {
+		targets = append(targets, target.Lhs)
+	}
+	at := func(n parser.Node) *parser.Node {
+		n.Line, n.Col = node.Line, node.Col
+		return &n
+	}
+	hidden := func(name string) *parser.Node {
+		return at(parser.Node{Type: parser.NodeIdentifier, Value: "for " + name})
+	}
+	integer := func(value int) *parser.Node {
+		return at(parser.Node{Type: parser.NodeIntLiteral, Value: fmt.Sprint(value)})
+	}
+	let := func(kind string, target *parser.Node, value *parser.Node) parser.Node {
+		return *at(parser.Node{Type: parser.NodeLet, Value: kind, Lhs: target, Rhs: value})
+	}
+	call := func(name string, args ...*parser.Node) *parser.Node {
+		return at(parser.Node{Type: parser.NodeCall, Value: name, Rhs: Params(args...)})
+	}
+	method := func(receiver *parser.Node, name string) *parser.Node {
+		return at(parser.Node{Type: parser.NodeMethodCall, Value: name, Lhs: receiver})
+	}
+	increment := *at(parser.Node{Type: parser.NodeAssign, Value: "+", Lhs: hidden("index"), Rhs: integer(1)})
+
+	// the body declares the index as the first of two targets and the value
+	// as the last
+	var body []parser.Node
+	declare := func(index *parser.Node, value *parser.Node) {
+		if len(targets) == 2 && targets[0].Value != "_" {
+			body = append(body, let("let", targets[0], index))
+		}
+		if value != nil && targets[len(targets)-1].Value != "_" {
+			body = append(body, let("let", targets[len(targets)-1], value))
+		}
+		body = append(body, *at(parser.Node{Type: parser.NodeScope, Stmts: node.Stmts}))
+	}
+
+	stmts := []parser.Node{let("let", hidden("value"), node.Rhs), let("var", hidden("index"), integer(0))}
+	var loop parser.Node
+	switch {
+	case ty.IsSlice() || ty.IsMap():
+		values := "value"
+		if ty.IsMap() {
+			values = "keys"
+			stmts = append(stmts, let("let", hidden("keys"), call("keys", hidden("value"))))
+		}
+		element := func() *parser.Node {
+			return at(parser.Node{Type: parser.NodeIndex, Lhs: hidden(values), Rhs: hidden("index")})
+		}
+		if ty.IsMap() && len(targets) == 2 {
+			declare(element(), at(parser.Node{Type: parser.NodeIndex, Lhs: hidden("value"), Rhs: element()}))
+		} else if ty.IsMap() {
+			declare(nil, element())
+		} else {
+			declare(hidden("index"), element())
+		}
+		body = append(body, increment)
+		loop = parser.Node{Type: parser.NodeFor, Lhs: at(parser.Node{Type: parser.NodeLt, Lhs: hidden("index"), Rhs: call("len", hidden(values))})}
+	case *ty == String:
+		next := *at(parser.Node{Type: parser.NodeAssign, Lhs: hidden("byte"), Rhs: call("byte_at", hidden("value"), hidden("index"))})
+		stmts = append(stmts, let("var", hidden("byte"), next.Rhs))
+		declare(hidden("index"), hidden("byte"))
+		body = append(body, increment, next)
+		loop = parser.Node{Type: parser.NodeFor, Lhs: at(parser.Node{Type: parser.NodeLt, Lhs: integer(0), Rhs: hidden("byte")})}
+	default:
+		if iter := tc.Method(*ty, "iter"); iter != nil && len(iter.Params) == 1 && len(iter.Returns) == 1 {
+			stmts[0].Rhs = method(node.Rhs, "iter")
+			ty = &iter.Returns[0]
+		}
+		if !tc.Overloadable(*ty) {
+			return fmt.Errorf("can't iterate over %s", *ty)
+		}
+		next := tc.Method(*ty, "next")
+		if next == nil || len(next.Params) != 1 || len(next.Returns) != 1 || !next.Returns[0].IsOptional() {
+			return fmt.Errorf("can't iterate over %s, it needs a next method returning an optional", *ty)
+		}
+		// the value is declared by an if let, which breaks out of the loop
+		// once there are no more
+		value := targets[len(targets)-1]
+		if value.Value == "_" {
+			value = hidden("item")
+		}
+		declare(hidden("index"), nil)
+		body = append(body, increment)
+		done := at(parser.Node{Type: parser.NodeScope, Stmts: &parser.StatementSequence{Statements: []parser.Node{{Type: parser.NodeBreak}}}})
+		ifLet := *at(parser.Node{Type: parser.NodeIfLet, Lhs: at(parser.Node{Type: parser.NodeLet, Value: "let", Lhs: value, Rhs: method(hidden("value"), "next")}), Stmts: &parser.StatementSequence{Statements: body}, Rhs: done})
+		body = []parser.Node{ifLet}
+		loop = parser.Node{Type: parser.NodeFor, Lhs: at(parser.Node{Type: parser.NodeLt, Lhs: integer(0), Rhs: integer(1)})}
+	}
+	loop.Stmts = &parser.StatementSequence{Statements: body}
+	loop.Line, loop.Col = node.Line, node.Col
+	stmts = append(stmts, loop)
+	*node = parser.Node{Type: parser.NodeScope, Stmts: &parser.StatementSequence{Statements: stmts}, Line: node.Line, Col: node.Col}
+	return nil
+}
+
+// CheckDeferredScope checks that nothing in a deferred scope leaves it early,
+// as it runs while the scope it was deferred in is being left. A break is
+// fine inside a loop of its own.
+func CheckDeferredScope(node *parser.Node, loop bool) error {
+	if node == nil {
+		return nil
+	}
+	switch node.Type {
+	case parser.NodeReturn:
+		return fmt.Errorf("can't return from a deferred scope")
+	case parser.NodeExit:
+		return fmt.Errorf("can't exit from a deferred scope")
+	case parser.NodeTry:
+		return fmt.Errorf("can't use try in a deferred scope")
+	case parser.NodeBreak:
+		if !loop {
+			return fmt.Errorf("can't break out of a deferred scope")
+		}
+	case parser.NodeFor, parser.NodeForIn:
+		loop = true
+	}
+	if node.Stmts != nil {
+		for i := range node.Stmts.Statements {
+			err := CheckDeferredScope(&node.Stmts.Statements[i], loop)
+			if err != nil {
+				return err
 			}
 		}
+	}
+	err := CheckDeferredScope(node.Lhs, loop)
+	if err != nil {
+		return err
+	}
+	return CheckDeferredScope(node.Rhs, loop)
+}
+
+// Block returns a checker for a scope nested in the current one.
+func (tc *TypeChecker) Block() *TypeChecker {
+	return &TypeChecker{scope: scope.New(tc.scope), functions: tc.functions, structs: tc.structs, interfaces: tc.interfaces, unions: tc.unions, templates: tc.templates, function: tc.function, nested: true, loop: tc.loop}
+}
+
+// Stringify lowers the value of a param to a string, converting it at runtime
+// if it is of another type.
+func (tc *TypeChecker) Stringify(param *parser.Node) error {
+	ty, err := tc.GetType(param.Lhs)
+	if err != nil {
+		return err
+	}
+
+	switch *ty {
+	case String:
+	case Int:
+		param.Lhs = &parser.Node{Type: parser.NodeCall, Value: "itoa", Rhs: &parser.Node{Type: parser.NodeParam, Lhs: param.Lhs}}
+	case Error:
+		param.Lhs = &parser.Node{Type: parser.NodeCall, Value: "error_text", Rhs: &parser.Node{Type: parser.NodeParam, Lhs: param.Lhs}}
+	default:
+		if ty.IsOptional() || *ty == None {
+			return Unwrapped(*ty)
+		}
+		return fmt.Errorf("can't convert value to a string")
+	}
+	return nil
+}
 
-		return nil, fmt.Errorf("variable not in scope")
-	} else if node.Type == parser.NodeAdd {
-		lhs, err := tc.GetType(node.Lhs)
+// CheckStructLiteral checks the fields of a struct literal, and puts them in
+// the order they're declared with zero values for any that are left out. Once
+// checked the literal has the struct's type in Lhs, in place of any type
+// arguments, like a slice literal.
+func (tc *TypeChecker) CheckStructLiteral(node *parser.Node) error {
+	if node.Lhs != nil && node.Lhs.Type == parser.NodeTypeName {
+		return nil
+	}
+	if node.Lhs != nil || tc.templates.types[node.Value] != nil {
+		ty, err := tc.ParseType(&parser.Node{Type: parser.NodeTypeName, Value: node.Value, Lhs: node.Lhs})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		rhs, err := tc.GetType(node.Rhs)
+		node.Value = string(ty)
+	}
+	s := tc.FindStruct(node.Value)
+	if s == nil {
+		return fmt.Errorf("unknown struct '%s'", node.Value)
+	}
+	values := make([]*parser.Node, len(s.Fields))
+	for entry := node.Rhs; entry != nil; entry = entry.Rhs {
+		i, field := s.Field(entry.Lhs.Lhs.Value)
+		if field == nil {
+			return fmt.Errorf("%s has no field '%s'", s.Name, entry.Lhs.Lhs.Value)
+		}
+		if values[i] != nil {
+			return fmt.Errorf("field '%s' given twice in %s literal", field.Name, s.Name)
+		}
+		ty, err := tc.GetType(entry.Lhs.Rhs)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if !tc.Convert(entry.Lhs.Rhs, *ty, field.Type) {
+			return fmt.Errorf("can't use %s as field '%s' of %s", *ty, field.Name, s.Name)
 		}
+		values[i] = entry.Lhs
+	}
 
-		if lhs != rhs {
-			return nil, fmt.Errorf("can't add variables of differing types")
+	var entries *parser.Node
+	for i := len(s.Fields) - 1; i >= 0; i-- {
+		if values[i] == nil {
+			values[i] = &parser.Node{Type: parser.NodeEntry, Lhs: &parser.Node{Type: parser.NodeIdentifier, Value: s.Fields[i].Name}, Rhs: tc.ZeroValue(s.Fields[i].Type)}
+			_, err := tc.GetType(values[i].Rhs)
+			if err != nil {
+				return err
+			}
 		}
-	} else if node.Type == parser.NodeCall {
-		// panic("type check not implemented")
-		ty = String
+		entries = &parser.Node{Type: parser.NodeParam, Lhs: values[i], Rhs: entries}
+	}
+	node.Lhs, node.Rhs = TypeNode(VarType(s.Name)), entries
+	return nil
+}
+
+// LowerMethodCall rewrites a method call, p.len(), into a call to the method
+// with the receiver as its first argument.
+func (tc *TypeChecker) LowerMethodCall(node *parser.Node) error {
+	if tc.IsUnionName(node.Lhs) {
+		return tc.LowerVariant(node, node.Rhs)
+	}
+	ty, err := tc.GetType(node.Lhs)
+	if err != nil {
+		return err
 	}
+	name := string(*ty) + "." + node.Value
+	if iface := tc.FindInterface(string(*ty)); iface != nil && iface.Method(node.Value) != -1 {
+		// called through the vtable of the interface value
+		index := &parser.Node{Type: parser.NodeIntLiteral, Value: fmt.Sprint(iface.Method(node.Value))}
+		*node = parser.Node{Type: parser.NodeDynamicCall, Value: name, Lhs: index, Rhs: &parser.Node{Type: parser.NodeParam, Lhs: node.Lhs, Rhs: node.Rhs}, Line: node.Line, Col: node.Col}
+		return nil
+	}
+	if tc.FindStruct(string(*ty)) == nil || tc.FindFunction(name) == nil {
+		return fmt.Errorf("%s has no method '%s'", *ty, node.Value)
+	}
+	*node = parser.Node{Type: parser.NodeCall, Value: name, Rhs: &parser.Node{Type: parser.NodeParam, Lhs: node.Lhs, Rhs: node.Rhs}, Line: node.Line, Col: node.Col}
+	return nil
+}
 
-	return &ty, nil
+// IsUnionName reports whether a node names a union, rather than a variable, as
+// in Token.Eof.
+func (tc *TypeChecker) IsUnionName(node *parser.Node) bool {
+	return node.Type == parser.NodeIdentifier && tc.FindVariable(node.Value) == nil && tc.FindUnion(node.Value) != nil
+}
+
+// LowerVariant rewrites making a variant of a union, Token.Int(5) or
+// Token.Eof, into a union value with the index of the variant and its values,
+// padded with zeros to the size of the largest variant.
+func (tc *TypeChecker) LowerVariant(node *parser.Node, args *parser.Node) error {
+	u := tc.FindUnion(node.Lhs.Value)
+	tag, variant := u.Variant(node.Value)
+	if variant == nil {
+		return fmt.Errorf("union '%s' has no variant '%s'", u.Name, node.Value)
+	}
+	var values []*parser.Node
+	for arg := args; arg != nil; arg = arg.Rhs {
+		values = append(values, arg.Lhs)
+	}
+	if len(values) != len(variant.Types) {
+		return fmt.Errorf("%s.%s has %d values, got %d", u.Name, variant.Name, len(variant.Types), len(values))
+	}
+	for i, value := range values {
+		ty, err := tc.GetType(value)
+		if err != nil {
+			return err
+		}
+		if !tc.Convert(value, *ty, variant.Types[i]) {
+			return fmt.Errorf("value %d of %s.%s should be %s, got %s", i+1, u.Name, variant.Name, variant.Types[i], *ty)
+		}
+	}
+	for len(values) < u.Size() {
+		values = append(values, &parser.Node{Type: parser.NodeIntLiteral, Value: "0"})
+	}
+	*node = parser.Node{Type: parser.NodeUnionValue, Value: u.Name, Lhs: &parser.Node{Type: parser.NodeIntLiteral, Value: fmt.Sprint(tag)}, Rhs: Params(values...), Line: node.Line, Col: node.Col}
+	return nil
+}
+
+// LowerMapIndex rewrites looking up a key in a map into a call to the runtime,
+// passing the zero value of the map's values for when the key is missing.
+func (tc *TypeChecker) LowerMapIndex(node *parser.Node, m VarType) error {
+	key, err := tc.GetType(node.Rhs)
+	if err != nil {
+		return err
+	}
+	if *key != m.Key() {
+		return fmt.Errorf("can't use %s as a key of %s", *key, m)
+	}
+	*node = parser.Node{Type: parser.NodeCall, Value: "map_get", Rhs: Params(node.Lhs, node.Rhs, tc.ZeroValue(m.Value())), Line: node.Line, Col: node.Col}
+	return nil
+}
+
+// the operators of compound assignments
+var compound_ops = map[string]parser.NodeType{
+	"+": parser.NodeAdd,
+	"-": parser.NodeSub,
+	"*": parser.NodeMulti,
+	"/": parser.NodeDiv,
+	"%": parser.NodeMod,
+}
+
+// LowerMapAssign rewrites setting the value of a key in a map, m[k] = v, into
+// a call to the runtime. Assignments to anything else are left alone.
+func (tc *TypeChecker) LowerMapAssign(node *parser.Node) error {
+	m, err := tc.GetType(node.Lhs.Lhs)
+	if err != nil {
+		return err
+	}
+	if !m.IsMap() {
+		return nil
+	}
+	if node.Value != "" {
+		// m[k] += v is m[k] = m[k] + v
+		if m.Value() != Int && !tc.Overloadable(m.Value()) {
+			return fmt.Errorf("%s= can only be used on ints, got %s", node.Value, m.Value())
+		}
+		current := &parser.Node{Type: parser.NodeIndex, Lhs: node.Lhs.Lhs, Rhs: node.Lhs.Rhs, Line: node.Lhs.Line, Col: node.Lhs.Col}
+		node.Rhs = &parser.Node{Type: compound_ops[node.Value], Lhs: current, Rhs: node.Rhs, Line: node.Line, Col: node.Col}
+	}
+	key, err := tc.GetType(node.Lhs.Rhs)
+	if err != nil {
+		return err
+	}
+	if *key != m.Key() {
+		return fmt.Errorf("can't use %s as a key of %s", *key, *m)
+	}
+	value, err := tc.GetType(node.Rhs)
+	if err != nil {
+		return err
+	}
+	if !tc.Convert(node.Rhs, *value, m.Value()) {
+		return fmt.Errorf("can't use %s as a value of %s", *value, *m)
+	}
+	*node = parser.Node{Type: parser.NodeCall, Value: "map_set", Rhs: Params(node.Lhs.Lhs, node.Lhs.Rhs, node.Rhs), Line: node.Line, Col: node.Col}
+	return nil
+}
+
+// GetTupleType returns the types of the values a call assigns to a tuple of
+// identifiers.
+func (tc *TypeChecker) GetTupleType(tuple *parser.Node, node *parser.Node) ([]VarType, error) {
+	if node.Type == parser.NodeCall && node.Value == "map_get" {
+		// a lowered m[k], which can also say whether the key was there
+		node.Value = "map_lookup"
+	}
+	if node.Type != parser.NodeCall && node.Type != parser.NodeDynamicCall {
+		return nil, fmt.Errorf("expected a function call returning multiple values")
+	}
+	fn, err := tc.CheckCall(node)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for target := tuple.Lhs; target != nil; target = target.Rhs {
+		count++
+	}
+	if count != len(fn.Returns) {
+		return nil, fmt.Errorf("'%s' returns %d values, but %d are assigned", fn.Name, len(fn.Returns), count)
+	}
+	return fn.Returns, nil
 }
 
 func (tc *TypeChecker) CheckNode(node *parser.Node) (*parser.Node, error) {
@@ -66,62 +2338,298 @@ func (tc *TypeChecker) CheckNode(node *parser.Node) (*parser.Node, error) {
 		return nil, nil
 	}
 
+	if node.Type == parser.NodeFunction {
+		return node, tc.CheckFunction(node)
+	}
+
+	if node.Type == parser.NodeStruct || node.Type == parser.NodeImpl || node.Type == parser.NodeInterface || node.Type == parser.NodeUnion || node.Type == parser.NodeGeneric {
+		if tc.nested {
+			return nil, fmt.Errorf("'%s' must be declared at the top level", node.Value)
+		}
+		if node.Type == parser.NodeGeneric {
+			return node, tc.ReachTemplate(node)
+		}
+		if node.Type == parser.NodeImpl {
+			for i := range node.Stmts.Statements {
+				err := tc.CheckFunction(&node.Stmts.Statements[i])
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return node, nil
+	}
+
+	if node.Type == parser.NodeAssign && node.Lhs.Type == parser.NodeIndex {
+		err := tc.LowerMapAssign(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Type == parser.NodeStructPattern || node.Type == parser.NodeVariant {
+		// patterns are checked along with the value they match
+		return node, nil
+	}
+
+	if node.Type == parser.NodeIfLet {
+		return node, tc.CheckIfLet(node)
+	}
+	if node.Type == parser.NodeForIn {
+		err := tc.LowerForIn(node)
+		if err != nil {
+			return nil, err
+		}
+		return tc.CheckNode(node)
+	}
+	if node.Type == parser.NodeMatch {
+		return node, tc.CheckMatch(node)
+	}
+
 	if node.Stmts != nil {
-		scope := TypeChecker{variables: tc.variables}
-		scope.TypeCheck(node.Stmts)
+		block := tc.Block()
+		if node.Type == parser.NodeFor {
+			block.loop = true
+		}
+		err := block.TypeCheck(node.Stmts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Type == parser.NodeDefer {
+		switch node.Lhs.Type {
+		case parser.NodeCall:
+			if tc.ReturnsResult(node.Lhs.Value) {
+				return nil, fmt.Errorf("the result of '%s' is dropped", node.Lhs.Value)
+			}
+		case parser.NodeScope:
+			err := CheckDeferredScope(node.Lhs, false)
+			if err != nil {
+				return nil, err
+			}
+		case parser.NodeMethodCall, parser.NodePrint, parser.NodePrintln, parser.NodeAssign:
+		default:
+			return nil, fmt.Errorf("only calls, prints, assignments and scopes can be deferred")
+		}
+	}
+
+	if node.Type == parser.NodeBreak && !tc.loop {
+		return nil, fmt.Errorf("break outside of a loop")
 	}
 
-	rhs, _ := tc.CheckNode(node.Rhs)
-	lhs, _ := tc.CheckNode(node.Lhs)
-	if node.Type == parser.NodeLet {
+	rhs, err := tc.CheckNode(node.Rhs)
+	if err != nil {
+		return nil, err
+	}
+	lhs, err := tc.CheckNode(node.Lhs)
+	if err != nil {
+		return nil, err
+	}
+	if node.Type == parser.NodeLet && lhs.Type == parser.NodeTuple {
+		types, err := tc.GetTupleType(lhs, rhs)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		for target := lhs.Lhs; target != nil; target = target.Rhs {
+			if target.Lhs.Value != "_" {
+				err := tc.DeclareLet(node, target.Lhs, types[i])
+				if err != nil {
+					return nil, err
+				}
+			}
+			i++
+		}
+	} else if node.Type == parser.NodeLet && lhs.Type != parser.NodeIdentifier {
+		err := tc.CheckDestructure(node)
+		if err != nil {
+			return nil, err
+		}
+	} else if node.Type == parser.NodeLet {
 		// infer the type
 		ty, err := tc.GetType(rhs)
 		if err != nil {
 			return nil, err
 		}
-		tc.variables = append(tc.variables, Variable{Name: lhs.Value, Type: *ty})
+		if *ty == None {
+			return nil, fmt.Errorf("can't infer the type of '%s' from none", lhs.Value)
+		}
+		err = tc.DeclareLet(node, lhs, *ty)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if node.Type == parser.NodeAssign {
+	if node.Type == parser.NodeAssign && node.Lhs.Type == parser.NodeTuple {
+		types, err := tc.GetTupleType(node.Lhs, node.Rhs)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		for target := node.Lhs.Lhs; target != nil; target = target.Rhs {
+			err := tc.CheckMutable(target.Lhs)
+			if err != nil {
+				return nil, err
+			}
+			ty, err := tc.GetType(target.Lhs)
+			if err != nil {
+				return nil, err
+			}
+			if *ty != types[i] {
+				return nil, fmt.Errorf("mismatched type when attempting to reassign variable")
+			}
+			i++
+		}
+	} else if node.Type == parser.NodeAssign {
+		err := tc.CheckMutable(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
 		lhs, err := tc.GetType(node.Lhs)
 		if err != nil {
 			return nil, err
 		}
+		if node.Value != "" && tc.Overloadable(*lhs) {
+			// v += w is v = v + w, using the method overloading +
+			current := *node.Lhs
+			node.Rhs = &parser.Node{Type: compound_ops[node.Value], Lhs: &current, Rhs: node.Rhs, Line: node.Line, Col: node.Col}
+			node.Value = ""
+		}
 		rhs, err := tc.GetType(node.Rhs)
 		if err != nil {
 			return nil, err
 		}
 
-		if lhs != rhs {
+		if !tc.Convert(node.Rhs, *rhs, *lhs) {
 			return nil, fmt.Errorf("mismatched type when attempting to reassign variable")
 		}
+		if node.Value != "" && *lhs != Int {
+			return nil, fmt.Errorf("%s= can only be used on ints, got %s", node.Value, *lhs)
+		}
 	}
 
-	if node.Type == parser.NodePrint {
-		lhs, err := tc.GetType(node.Lhs)
+	if node.Type == parser.NodeMethodCall {
+		err := tc.LowerMethodCall(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Type == parser.NodeCall || node.Type == parser.NodeDynamicCall {
+		_, err := tc.CheckCall(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Type == parser.NodeTry || node.Type == parser.NodeIndex || node.Type == parser.NodeField || node.Type == parser.NodeStructLiteral {
+		_, err := tc.GetType(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Type == parser.NodeLt || node.Type == parser.NodeGt || node.Type == parser.NodeEq {
+		err := tc.CheckComparison(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if node.Type == parser.NodeExit && node.Lhs != nil {
+		ty, err := tc.GetType(node.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if ty.IsOptional() || *ty == None {
+			return nil, Unwrapped(*ty)
+		}
+	}
+
+	if node.Type == parser.NodeReturn {
+		err := tc.CheckReturn(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Type == parser.NodeAssert && node.Rhs != nil {
+		err := tc.Stringify(node.Rhs)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		if *lhs != String {
-			return nil, fmt.Errorf("print expects a string")
+	if node.Type == parser.NodePrint || node.Type == parser.NodePrintln {
+		for param := node.Lhs; param != nil; param = param.Rhs {
+			err := tc.Stringify(param)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	return node, nil
 }
 
 func (tc *TypeChecker) TypeCheck(seq *parser.StatementSequence) error {
+	if tc.scope == nil {
+		tc.scope = scope.New[Variable](nil)
+	}
+	if tc.templates == nil {
+		tc.templates = &Templates{
+			functions: map[string]*parser.Node{},
+			types:     map[string]*parser.Node{},
+			impls:     map[string][]*parser.Node{},
+			instances: map[string]*Function{},
+			structs:   map[string]*Struct{},
+			scopes:    map[*parser.Node]*scope.Scope[Variable]{},
+			pending:   map[*parser.Node][]*parser.Node{},
+		}
+	}
+	if !tc.nested {
+		err := tc.DeclareTypes(seq)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(seq.Statements); i++ {
+			var err error
+			switch seq.Statements[i].Type {
+			case parser.NodeFunction:
+				err = tc.DeclareFunction(&seq.Statements[i])
+			case parser.NodeImpl:
+				err = tc.DeclareMethods(&seq.Statements[i])
+			case parser.NodeInterface:
+				err = tc.DeclareInterface(&seq.Statements[i])
+			case parser.NodeGeneric:
+				if seq.Statements[i].Rhs.Type == parser.NodeImpl {
+					err = tc.CheckGenericImpl(&seq.Statements[i])
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	for i := 0; i < len(seq.Statements); i++ {
-		_, err := tc.CheckNode(&seq.Statements[i])
+		stmt, err := tc.CheckNode(&seq.Statements[i])
 		if err != nil {
 			return err
 		}
+
+		if (stmt.Type == parser.NodeCall || stmt.Type == parser.NodeDynamicCall) && tc.ReturnsResult(stmt.Value) {
+			return fmt.Errorf("the result of '%s' is dropped", stmt.Value)
+		}
+		if stmt.Type == parser.NodeUnionValue {
+			return fmt.Errorf("%s value isn't used", stmt.Value)
+		}
 	}
 	return nil
 }
 
 func main() {
 	output := flag.String("o", "out", "output file name")
+	checked := flag.Bool("checked", false, "trap division by zero and integer overflow at runtime")
 	flag.Parse()
 	source := flag.Arg(0)
 	if source == "" {
@@ -153,7 +2661,7 @@ func main() {
 
 	asm_fn := *output + ".asm"
 	o_fn := *output + ".o"
-	generator.Generate(ast, asm_fn)
+	generator.Generate(ast, source, asm_fn, *checked)
 
 	// cmd := exec.Command("nasm", "-f", "macho64", "test.a", "-o", "test.o")
 	cmd := exec.Command("nasm", "-f", "elf64", asm_fn, "-o", o_fn)
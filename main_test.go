@@ -0,0 +1,447 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"longden.me/blang/generator"
+	"longden.me/blang/parser"
+	"longden.me/blang/tokeniser"
+)
+
+// check tokenises, parses and type checks a program, which must parse.
+func check(t *testing.T, src string) (*parser.StatementSequence, error) {
+	t.Helper()
+	tokens, err := tokeniser.Tokenise([]byte(src))
+	if err != nil {
+		t.Fatalf("unexpected token error: %s", err)
+	}
+	p := parser.Parser{Tokens: tokens}
+	ast, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected parse error: %s", err)
+	}
+	tc := TypeChecker{}
+	return ast, tc.TypeCheck(ast)
+}
+
+// compile type checks a program and returns the assembly generated for it.
+func compile(t *testing.T, src string) string {
+	t.Helper()
+	ast, err := check(t, src)
+	if err != nil {
+		t.Fatalf("unexpected type error: %s", err)
+	}
+	fn := filepath.Join(t.TempDir(), "test.asm")
+	generator.Generate(ast, "test.bl", fn, false)
+	output, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("unexpected error reading the assembly: %s", err)
+	}
+	return string(output)
+}
+
+// accepts checks that a program type checks, and generates it.
+func accepts(t *testing.T, src string) {
+	t.Helper()
+	compile(t, src)
+}
+
+// rejects checks that a program fails to type check with an error containing
+// message.
+func rejects(t *testing.T, src string, message string) {
+	t.Helper()
+	_, err := check(t, src)
+	if err == nil {
+		t.Fatalf("expected an error containing %q", message)
+	}
+	if !strings.Contains(err.Error(), message) {
+		t.Errorf("expected an error containing %q, got %q", message, err)
+	}
+}
+
+func TestSliceBoundsChecks(t *testing.T) {
+	output := compile(t, "s := []int{1, 2}\nn := s[1]\nu := s[0:1]\nexit n")
+	for _, check := range []string{"cmp rax, [rbx + 8]\n    jb ", "test.bl:2:7: index out of range", "cmp rdx, [rdi + 8]\n    jle ", "test.bl:3:7: slice bounds out of range"} {
+		if !strings.Contains(output, check) {
+			t.Errorf("expected %q in:\n%s", check, output)
+		}
+	}
+}
+
+func TestAppendWrongElementType(t *testing.T) {
+	rejects(t, "s := []int{1}\nappend(s, \"a\")", "argument 2 of 'append' has the wrong type")
+	rejects(t, "s := []int{1, \"a\"}", "can't use string as an element of []int")
+}
+
+func TestMapKeyAndValueTypes(t *testing.T) {
+	rejects(t, "m := map[string]int{1: 2}", "can't use int as a key of map[string]int")
+	rejects(t, "m := map[string]int{\"a\": \"b\"}", "can't use string as a value of map[string]int")
+	rejects(t, "m := map[string]int{}\nm[1] = 2", "can't use int as a key of map[string]int")
+	rejects(t, "m := map[string]int{}\nm[\"a\"] = \"b\"", "can't use string as a value of map[string]int")
+	rejects(t, "m := map[string]int{}\nn := m[2]", "can't use int as a key of map[string]int")
+	accepts(t, "m := map[string]int{\"a\": 1}\nm[\"b\"] = 2\nexit m[\"a\"]")
+}
+
+func TestAssignToLet(t *testing.T) {
+	message := "can't assign to 'n', declared with let at line 2, col 4"
+	rejects(t, "exit 0\nlet n = 1\nn = 2", message)
+	rejects(t, "exit 0\nlet n = 1\nn += 2", message)
+	rejects(t, "exit 0\nlet n = 1\nn++", message)
+	rejects(t, "exit 0\nlet n = 1\nn--", message)
+	rejects(t, "exit 0\nlet n = 1\nfn f() {\n n = 2\n}", message)
+}
+
+func TestAssignToVar(t *testing.T) {
+	accepts(t, "var n = 1\nn = 2\nn += 2\nn++\nm := 0\nm--\nexit n + m")
+}
+
+func TestAssignToParameter(t *testing.T) {
+	accepts(t, "fn f(n int) int {\n n = n * 2\n n++\n return n\n}\nexit f(1)")
+}
+
+func TestInnerLetHidesOuter(t *testing.T) {
+	// x is a string inside the block and an int again after it
+	accepts(t, "let x = 1\n{\n let x = \"inner\"\n println x\n}\nexit x + 1")
+	rejects(t, "let x = 1\n{\n let x = \"inner\"\n let n = x + 1\n}", "can't add")
+}
+
+func TestInnerLetNotVisibleAfterBlock(t *testing.T) {
+	rejects(t, "{\n let y = 1\n println y\n}\nlet z = y", "variable 'y' not in scope")
+	rejects(t, "if 1 < 2 {\n let y = 1\n println y\n}\nlet z = y", "variable 'y' not in scope")
+	rejects(t, "fn f() {\n let y = 1\n println y\n}\nlet z = y", "variable 'y' not in scope")
+}
+
+func TestRedeclareInSameScope(t *testing.T) {
+	rejects(t, "let x = 1\nlet x = 2", "'x' already declared at line 1, col 4")
+	rejects(t, "{\n let x = 1\n var x = 2\n}", "'x' already declared at line 2, col 5")
+}
+
+func TestShadowingInNestedFunctionScopes(t *testing.T) {
+	accepts(t, "fn f(x int) int {\n if x > 0 {\n let x = \"positive\"\n println x\n }\n return x\n}\nexit f(2)")
+}
+
+const sized = "interface Sized {\n fn size(self) int\n}\nfn total(s Sized) int {\n return s.size()\n}\n"
+
+func TestInterfaceMissingMethod(t *testing.T) {
+	rejects(t, sized+"struct Bag {\n n int\n}\nexit total(Bag{})", "Bag doesn't implement Sized, it has no method 'size'")
+}
+
+func TestInterfaceWrongSignature(t *testing.T) {
+	rejects(t, sized+"struct Box {\n n int\n}\nimpl Box {\n fn size(self, x int) int {\n return x\n }\n}\nexit total(Box{})", "Box doesn't implement Sized, method 'size' has the wrong signature")
+	rejects(t, sized+"struct Box {\n n int\n}\nimpl Box {\n fn size(self) string {\n return \"a\"\n }\n}\nexit total(Box{})", "Box doesn't implement Sized, method 'size' has the wrong signature")
+}
+
+const box = "struct Box {\n n int\n}\nimpl Box {\n fn size(self) int {\n return self.n\n }\n}\n"
+
+func TestInterfaceCallsThroughVtable(t *testing.T) {
+	output := compile(t, sized+box+"exit total(Box{n: 3})")
+	for _, check := range []string{"vtable_Box.Sized dq fn_Box.size, 0\n", "lea rcx, [rel vtable_Box.Sized]", "cmp rdi, 0\n    jne ", "call to Sized.size on an empty interface", "mov rax, [rdi + 8] ; vtable\n", "call [rax + 0*8] ; Sized.size\n"} {
+		if !strings.Contains(output, check) {
+			t.Errorf("expected %q in:\n%s", check, output)
+		}
+	}
+}
+
+func TestGenericTypeArgumentsMustAgree(t *testing.T) {
+	rejects(t, "fn max[T](a T, b T) T {\n return a\n}\nexit max(1, \"a\")", "argument 2 of 'max[int]' has the wrong type")
+}
+
+func TestGenericInstantiatedOncePerType(t *testing.T) {
+	output := compile(t, "fn id[T](a T) T {\n return a\n}\nx := id(1)\ny := id(\"a\")\nz := id(2)\nexit x + z")
+	for _, label := range []string{"fn_id$lint$r:\n", "fn_id$lstring$r:\n"} {
+		if strings.Count(output, label) != 1 {
+			t.Errorf("expected one copy of %q in:\n%s", label, output)
+		}
+	}
+}
+
+const maybe = "fn find(x int) ?int {\n if x > 0 {\n return x\n }\n return none\n}\nlet m = find(3)\n"
+
+func TestOptionalMustBeUnwrapped(t *testing.T) {
+	message := "?int must be unwrapped with if let or match before it's used"
+	rejects(t, maybe+"let n = m + 1", message)
+	rejects(t, maybe+"let n = 1 * m", message)
+	rejects(t, maybe+"if m < 2 {\n exit 1\n}", message)
+	rejects(t, maybe+"if m == 2 {\n exit 1\n}", message)
+	rejects(t, maybe+"fn twice(n int) int {\n return n * 2\n}\nexit twice(m)", "argument 1 of 'twice' has the wrong type")
+	rejects(t, maybe+"exit m", message)
+	rejects(t, maybe+"println m", message)
+	rejects(t, maybe+"exit none", "none can only be used as an optional")
+}
+
+func TestOptionalUnwrapped(t *testing.T) {
+	accepts(t, maybe+"if let n = m {\n exit n + 1\n} else {\n exit 0\n}")
+	accepts(t, maybe+"match m {\n Some(n) => exit n * 2\n none => exit 0\n}")
+	accepts(t, maybe+"if m == none {\n exit 1\n}\nassert m == none, \"missing\"")
+	rejects(t, maybe+"if let n = 3 {\n exit n\n}", "if let expects an optional, got int")
+}
+
+const token = "union Token {\n Int(int)\n Pair(int, string)\n Eof\n}\n"
+
+func TestUnionWrongPayload(t *testing.T) {
+	rejects(t, token+"let x = Token.Int(\"a\")", "value 1 of Token.Int should be int, got string")
+	rejects(t, token+"let x = Token.Pair(1)", "Token.Pair has 2 values, got 1")
+}
+
+func TestUnionUnknownVariant(t *testing.T) {
+	rejects(t, token+"let x = Token.Word(\"a\")", "union 'Token' has no variant 'Word'")
+}
+
+func TestUnionLayout(t *testing.T) {
+	// every value has room for the variant and the two values of the largest
+	output := compile(t, token+"let a = Token.Eof\nlet b = Token.Int(5)\nlet c = Token.Pair(1, \"a\")\nexit 0")
+	for _, check := range []string{"mov rdi, 24\n    call alloc\n    mov qword [rax], 2 ; variant\n", "mov rdi, 24\n    call alloc\n    mov qword [rax], 0 ; variant\n", "mov rdi, 24\n    call alloc\n    mov qword [rax], 1 ; variant\n"} {
+		if !strings.Contains(output, check) {
+			t.Errorf("expected %q in:\n%s", check, output)
+		}
+	}
+}
+
+const money = `struct Money {
+    cents int
+}
+
+impl Money {
+    fn add(self, o Money) Money {
+        return Money{cents: self.cents + o.cents}
+    }
+    fn sub(self, o Money) Money {
+        return Money{cents: self.cents - o.cents}
+    }
+    fn mul(self, n int) Money {
+        return Money{cents: self.cents * n}
+    }
+    fn lt(self, o Money) int {
+        return o.cents - self.cents
+    }
+    fn eq(self, o Money) int {
+        if self.cents == o.cents {
+            return 1
+        }
+        return 0
+    }
+}
+
+let a = Money{cents: 150}
+let b = Money{cents: 275}
+`
+
+func TestOverloadedArithmetic(t *testing.T) {
+	accepts(t, money+"let c = a + b - a * 2\nexit c.cents")
+}
+
+func TestOverloadedComparisons(t *testing.T) {
+	accepts(t, money+"if a < b {\n exit 1\n}\nif a > b {\n exit 2\n}\nif a == b {\n exit 3\n}\nassert a + b == b + a")
+}
+
+func TestOverloadedCompoundAssignment(t *testing.T) {
+	accepts(t, money+"var total = Money{}\ntotal += a\ntotal -= b\ntotal *= 3\nm := map[string]Money{}\nm[\"x\"] += a\nexit total.cents")
+}
+
+func TestCompoundAssignmentThroughOverloadNeedsVar(t *testing.T) {
+	rejects(t, money+"a += b", "can't assign to 'a', declared with let")
+}
+
+func TestOperatorWithoutMethod(t *testing.T) {
+	rejects(t, money+"let c = a / b", "Money has no method 'div' to overload the operator with")
+	rejects(t, money+"let c = a % b", "Money has no method 'rem' to overload the operator with")
+}
+
+func TestOverloadWithWrongOperand(t *testing.T) {
+	rejects(t, money+"let c = a + 1", "argument 2 of 'Money.add' has the wrong type")
+	rejects(t, money+"let c = a * b", "argument 2 of 'Money.mul' has the wrong type")
+}
+
+func TestComparisonOverloadMustReturnInt(t *testing.T) {
+	src := "struct P {\n x int\n}\nimpl P {\n fn lt(self, o P) P {\n return o\n }\n}\nlet p = P{}\nif p < p {\n exit 1\n}"
+	rejects(t, src, "P.lt must return an int to overload a comparison, got P")
+}
+
+func TestEqualityWithoutOverloadIsIdentity(t *testing.T) {
+	accepts(t, "struct P {\n x int\n}\nlet p = P{}\nif p == p {\n exit 1\n}")
+	rejects(t, "struct P {\n x int\n}\nlet p = P{}\nif p < p {\n exit 1\n}", "P has no method 'lt' to overload the operator with")
+}
+
+const shapes = `struct Point {
+    x int
+    y int
+}
+
+union Shape {
+    Dot(Point),
+    Circle(Point, int),
+    Empty
+}
+`
+
+func TestMatchMissingVariant(t *testing.T) {
+	rejects(t, shapes+"fn f(s Shape) int {\n match s {\n Dot(p) => return p.x\n Empty => return 0\n }\n return 0\n}", "match of Shape doesn't handle Circle(_, _)")
+}
+
+func TestMatchMissingNone(t *testing.T) {
+	rejects(t, "fn f(n ?int) int {\n match n {\n Some(v) => return v\n }\n return 0\n}", "match of ?int doesn't handle none")
+}
+
+func TestMatchEveryVariant(t *testing.T) {
+	accepts(t, shapes+"fn f(s Shape) int {\n match s {\n Dot(p) => return p.x\n Circle(_, r) => return r\n Empty => return 0\n }\n return 0\n}\nexit f(Shape.Empty)")
+}
+
+func TestGuardsDontMakeMatchExhaustive(t *testing.T) {
+	rejects(t, "fn f(n ?int) int {\n match n {\n Some(v) if v > 0 => return v\n none => return 0\n }\n return 0\n}", "match of ?int doesn't handle Some(_)")
+	accepts(t, "fn f(n ?int) int {\n match n {\n Some(v) if v > 0 => return v\n Some(v) => return 0\n none => return 0\n }\n return 0\n}\nexit f(3)")
+}
+
+func TestNestedStructPatterns(t *testing.T) {
+	src := shapes + "fn f(s Shape) int {\n match s {\n Dot(Point{x: 0, y}) => return y\n Circle(Point{x, y: 0}, 1) => return x\n %s\n Empty => return 0\n }\n return 0\n}"
+	rejects(t, strings.Replace(src, "%s", "Dot(Point{x: 1}) => return 1", 1), "match of Shape doesn't handle Dot(Point{x: 2})")
+	rejects(t, strings.Replace(src, "%s", "Dot(p) => return 1", 1), "match of Shape doesn't handle Circle(Point{y: 1}, _)")
+	accepts(t, strings.Replace(src, "%s", "Dot(_) => return 1\n Circle(_, _) => return 2", 1)+"\nexit f(Shape.Empty)")
+}
+
+func TestUnreachableArm(t *testing.T) {
+	rejects(t, "fn f(n ?int) int {\n match n {\n Some(v) => return v\n Some(0) => return 0\n none => return 0\n }\n return 0\n}", "unreachable arm in match at line 4")
+	rejects(t, shapes+"fn f(s Shape) int {\n match s {\n other => return 1\n Empty => return 0\n }\n return 0\n}", "unreachable arm in match at line 14")
+}
+
+func TestLetPatternMustMatchEveryValue(t *testing.T) {
+	rejects(t, "fn f(n ?int) int {\n let Some(v) = n\n return v\n}", "pattern in let doesn't match every ?int, such as none")
+	rejects(t, shapes+"let Point{x: 0, y} = Point{}", "pattern in let doesn't match every Point, such as Point{x: 1}")
+	accepts(t, shapes+"let Point{x, y} = Point{x: 1, y: 2}\nexit x + y")
+}
+
+func TestAssignToPatternBinding(t *testing.T) {
+	rejects(t, shapes+"let Point{x, y} = Point{}\nx = 1", "can't assign to 'x', declared with let at line 11, col 10")
+	rejects(t, "fn f(n ?int) int {\n match n {\n Some(v) => {\n v++\n return v\n }\n none => return 0\n }\n return 0\n}", "can't assign to 'v', declared with let at line 3, col 6")
+	rejects(t, maybe+"if let n = m {\n n += 1\n}", "can't assign to 'n'")
+	rejects(t, "for x in []int{1} {\n x = 2\n}", "can't assign to 'x'")
+	accepts(t, shapes+"var Point{x, y} = Point{}\nx = 1\ny += x\nexit y")
+}
+
+const counter = "struct Counter {\n n int\n}\nimpl Counter {\n fn next(self) ?int {\n if self.n > 2 {\n return none\n }\n self.n++\n return self.n\n }\n}\n"
+
+func TestForInNext(t *testing.T) {
+	accepts(t, counter+"total := 0\nfor x in Counter{} {\n total += x\n}\nexit total")
+	accepts(t, counter+"for i, x in Counter{} {\n println i, x\n}")
+}
+
+func TestForInIter(t *testing.T) {
+	accepts(t, counter+"struct Range {\n n int\n}\nimpl Range {\n fn iter(self) Counter {\n return Counter{n: self.n}\n }\n}\nr := Range{n: 1}\nfor x in r {\n println x\n}")
+}
+
+func TestForInNeedsNextOrIter(t *testing.T) {
+	rejects(t, "struct Bag {\n n int\n}\nfor x in Bag{} {\n println x\n}", "can't iterate over Bag, it needs a next method returning an optional")
+	rejects(t, "for x in 5 {\n println x\n}", "can't iterate over int")
+}
+
+func TestForInIndexAndValue(t *testing.T) {
+	accepts(t, "for i, c in \"abc\" {\n println i, c\n}\nfor i, s in []string{\"a\"} {\n println i, s\n}")
+	// the index is an int and the value the element, a byte of a string
+	accepts(t, "for i, c in \"abc\" {\n x := i + c\n}")
+	rejects(t, "for i, c in \"abc\" {\n x := c + \"a\"\n}", "can't add variables of differing types")
+	rejects(t, "for i, s in []string{\"a\"} {\n x := i + s\n}", "can't add variables of differing types")
+}
+
+func TestReturnInDeferredScope(t *testing.T) {
+	rejects(t, "fn f() { defer { return } println \"x\" }", "can't return from a deferred scope")
+}
+
+func TestBreakInDeferredScope(t *testing.T) {
+	rejects(t, "x := 0\nfor x < 3 { defer { break } x++ }", "can't break out of a deferred scope")
+}
+
+func TestExitInDeferredScope(t *testing.T) {
+	rejects(t, "fn f() {\n defer {\n if 1 < 2 {\n exit 1\n }\n }\n println \"x\"\n}", "can't exit from a deferred scope")
+}
+
+func TestBreakInLoopInDeferredScope(t *testing.T) {
+	accepts(t, "fn f() {\n defer {\n i := 0\n for i < 3 {\n break\n }\n }\n if 1 < 2 {\n return\n }\n println \"x\"\n}\nf()")
+}
+
+func TestTooManyParameters(t *testing.T) {
+	rejects(t, "fn f(a int, b int, c int, d int, e int, f int, g int) int {\n return a\n}", "'f' has 7 parameters, at most 6 are allowed")
+}
+
+func TestTooManyResults(t *testing.T) {
+	rejects(t, "fn f() (int, int, int, int, int, int, int) {\n return 1, 2, 3, 4, 5, 6, 7\n}", "'f' returns 7 values, at most 6 are allowed")
+}
+
+func TestTooManyMethodParameters(t *testing.T) {
+	rejects(t, "struct P {\n x int\n}\nimpl P {\n fn f(self, a int, b int, c int, d int, e int, f int) int {\n return a\n }\n}", "at most 6 are allowed")
+}
+
+func TestTooManyArguments(t *testing.T) {
+	rejects(t, "fn f(a int, b int, c int, d int, e int, f int) int {\n return a\n}\nf(1, 2, 3, 4, 5, 6, 7)", "too many arguments in call to 'f', at most 6 are allowed")
+}
+
+func TestSixParametersAndResults(t *testing.T) {
+	accepts(t, "fn f(a int, b int, c int, d int, e int, f int) (int, int, int, int, int, int) {\n return f, e, d, c, b, a\n}\nlet (a, b, c, d, e, g) = f(1, 2, 3, 4, 5, 6)\nexit a")
+}
+
+func TestVariadicArgumentsAreOneValue(t *testing.T) {
+	accepts(t, "fn sum(xs ...int) int {\n return len(xs)\n}\nexit sum(1, 2, 3, 4, 5, 6, 7, 8)")
+}
+
+func TestReadLinesUntilEOF(t *testing.T) {
+	accepts(t, "for eof() == 0 {\n line := readln()\n println line\n}")
+	rejects(t, "exit eof(0)", "too many arguments in call to 'eof'")
+}
+
+func TestArithmeticOnlyOnInts(t *testing.T) {
+	rejects(t, "s := []int{1}\nt := s + s", "'+' can only be used on ints, got []int")
+	rejects(t, "m := map[string]int{\"a\": 1}\nn := m * 2", "'*' can only be used on ints, got map[string]int")
+	rejects(t, "s := \"a\" + \"b\"", "'+' can only be used on ints, got string")
+	rejects(t, "s := \"a\"\nn := 1 - s", "'-' can only be used on ints, got string")
+	accepts(t, "a := 1\nb := (a + 2) * a / 3 % 4 - a\nexit b")
+}
+
+func TestArithmeticWithStructOnRight(t *testing.T) {
+	rejects(t, money+"x := 1 - a", "'-' can only be used on ints, got Money")
+}
+
+func TestIfExpressionWithNone(t *testing.T) {
+	accepts(t, "fn find(x int) ?int {\n return if x > 0 { x } else { none }\n}\nexit 0")
+	accepts(t, maybe+"let n = if 1 > 2 { none } else { m }\nexit 0")
+}
+
+func TestIfExpressionConvertsToInterface(t *testing.T) {
+	accepts(t, "interface Sized {\n fn size(self) int\n}\nstruct Box {\n n int\n}\nimpl Box {\n fn size(self) int {\n return self.n\n }\n}\nfn pick(b Box, s Sized) Sized {\n return if b.n > 0 { b } else { s }\n}\nexit pick(Box{n: 1}, Box{}).size()")
+}
+
+func TestIfExpressionBranchMismatch(t *testing.T) {
+	rejects(t, "let x = if 1 > 2 { 1 } else { \"a\" }", "branches of if have different types, int and string")
+	rejects(t, maybe+"let n = if 1 > 2 { m } else { \"a\" }", "branches of if have different types, ?int and string")
+}
+
+const reader = "struct Reader {\n n int\n}\nimpl Reader {\n fn next(self) Result[int] {\n if self.n > 2 {\n return error(\"done\")\n }\n self.n++\n return self.n\n }\n}\nr := Reader{}\n"
+
+func TestTryMethodCall(t *testing.T) {
+	accepts(t, reader+"fn sum(r Reader) Result[int] {\n a := try r.next()\n return a + try r.next()\n}\nn := try sum(r)\nexit n")
+}
+
+func TestTryMethodCallThroughInterface(t *testing.T) {
+	accepts(t, reader+"interface Source {\n fn next(self) Result[int]\n}\nfn first(s Source) Result[int] {\n return try s.next()\n}\nn := try first(r)\nexit n")
+}
+
+func TestResultMustBeHandled(t *testing.T) {
+	rejects(t, reader+"r.next()", "the result of 'Reader.next' is dropped")
+	rejects(t, reader+"n := r.next()", "the result of 'Reader.next' must be handled with try")
+	accepts(t, reader+"n, err := r.next()\nif err {\n exit 1\n}\nexit n")
+}
+
+func TestTryOutsideResultFunction(t *testing.T) {
+	rejects(t, reader+"fn f(r Reader) int {\n return try r.next()\n}", "try used in 'f', which doesn't return a Result")
+	rejects(t, "fn f() int {\n return 1\n}\nn := try f()", "try used on 'f', which doesn't return a Result")
+}
+
+func TestSpreadWrongElementType(t *testing.T) {
+	rejects(t, "fn sum(xs ...int) int {\n return len(xs)\n}\nstrs := []string{\"a\"}\nexit sum(strs...)", "can't pass []string as the variadic arguments of 'sum', expected []int")
+	rejects(t, "fn sum(xs ...int) int {\n return len(xs)\n}\nexit sum(1, \"a\")", "argument 2 of 'sum' has the wrong type")
+}
+
+func TestArgsIsSliceOfStrings(t *testing.T) {
+	accepts(t, "for i, a in args() {\n println i, a\n}\nexit len(args())")
+	rejects(t, "n := args() + args()", "'+' can only be used on ints, got []string")
+}
@@ -52,6 +52,51 @@ const (
 	NodePrintln
 	NodeParam
 	NodeCall
+	NodeInterpolate
+	NodeFunction
+	NodeReturn
+	NodeTypeName
+	NodeTuple
+	NodeDefer
+	NodeBreak
+	NodeTry
+	NodeAssert
+	NodeSliceType
+	NodeSliceLiteral
+	NodeIndex
+	NodeSlice
+	NodeRange
+	NodeMapType
+	NodeMapLiteral
+	NodeEntry
+	NodeMod
+	NodeIfExpr
+	NodeBranches
+	NodeStruct
+	NodeStructLiteral
+	NodeField
+	NodeImpl
+	NodeMethodCall
+	NodeInterface
+	NodeGeneric
+	NodeOptionalType
+	NodeNone
+	NodeIfLet
+	NodeMatch
+	NodeArm
+	NodeVariant
+	NodeUnion
+	NodeVariadicType
+	NodeSpread // a slice passed on as the variadic arguments of a call, xs...
+	NodeStructPattern
+	NodeForIn
+	// made by the type checker, a struct converted to an interface and a
+	// call to a method of an interface
+	NodeInterfaceValue
+	NodeDynamicCall
+	NodeSome       // a value wrapped in an optional
+	NodeUnionValue // a variant of a union with its values
+	NodeVarArgs    // the variadic arguments of a call, packed into a slice
 )
 
 type StatementSequence struct {
@@ -64,6 +109,8 @@ type Node struct {
 	Lhs   *Node
 	Rhs   *Node
 	Stmts *StatementSequence
+	Line  int
+	Col   int
 }
 
 func ParseError(message string, token *tokeniser.Token) error {
@@ -74,26 +121,1136 @@ func (s *StatementSequence) append(node *Node) {
 	s.Statements = append(s.Statements, *node)
 }
 
+// parse_params parses a comma separated list of expressions into a chain of
+// NodeParam nodes, each holding its value in Lhs and the next param in Rhs.
+func (t *Parser) parse_params() (*Node, error) {
+	value, err := t.parse_expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		if t.peek() == nil {
+			return nil, fmt.Errorf("unexpected EOF")
+		}
+		return nil, ParseError("expected expression", t.peek())
+	}
+
+	if t.peek() != nil && t.peek().Type == tokeniser.Ellipsis {
+		c := t.consume()
+		value = &Node{Type: NodeSpread, Lhs: value, Line: c.Line, Col: c.Col}
+	}
+
+	param := &Node{Type: NodeParam, Lhs: value}
+	if t.peek() != nil && t.peek().Type == tokeniser.Comma {
+		t.consume()
+		param.Rhs, err = t.parse_params()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return param, nil
+}
+
+func (t *Parser) parse_type() (*Node, error) {
+	if t.peek() == nil {
+		return nil, fmt.Errorf("unexpected EOF")
+	}
+	if t.peek().Type == tokeniser.Lbracket {
+		c := t.consume()
+		if t.peek() == nil || t.peek().Type != tokeniser.Rbracket {
+			return nil, ParseError("expected ']'", c)
+		}
+		t.consume()
+		elem, err := t.parse_type()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: NodeSliceType, Lhs: elem}, nil
+	}
+	if t.peek().Type == tokeniser.Question {
+		t.consume()
+		ty, err := t.parse_type()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: NodeOptionalType, Lhs: ty}, nil
+	}
+	if t.peek().Type == tokeniser.Map {
+		c := t.consume()
+		if t.peek() == nil || t.peek().Type != tokeniser.Lbracket {
+			return nil, ParseError("expected '['", c)
+		}
+		t.consume()
+		key, err := t.parse_type()
+		if err != nil {
+			return nil, err
+		}
+		if t.peek() == nil || t.peek().Type != tokeniser.Rbracket {
+			return nil, ParseError("expected ']'", c)
+		}
+		t.consume()
+		value, err := t.parse_type()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: NodeMapType, Lhs: key, Rhs: value}, nil
+	}
+	if t.peek().Type != tokeniser.Identifier {
+		return nil, ParseError("expected type", t.peek())
+	}
+	ty := &Node{Type: NodeTypeName, Value: t.consume().Value}
+
+	// type arguments, as in Result[int]
+	if t.peek() != nil && t.peek().Type == tokeniser.Lbracket {
+		args, err := t.parse_type_args()
+		if err != nil {
+			return nil, err
+		}
+		ty.Lhs = args
+	}
+	return ty, nil
+}
+
+// parse_type_args parses the type arguments of a generic type, [int, string],
+// into a chain of NodeParam each holding a type.
+func (t *Parser) parse_type_args() (*Node, error) {
+	c := t.consume() // [
+	var args, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rbracket {
+		if args != nil {
+			if t.peek().Type != tokeniser.Comma {
+				return nil, ParseError("expected ','", t.peek())
+			}
+			t.consume()
+		}
+		ty, err := t.parse_type()
+		if err != nil {
+			return nil, err
+		}
+
+		arg := &Node{Type: NodeParam, Lhs: ty}
+		if args == nil {
+			args = arg
+		} else {
+			tail.Rhs = arg
+		}
+		tail = arg
+	}
+	if t.peek() == nil || args == nil {
+		return nil, ParseError("expected type", c)
+	}
+	t.consume() // ]
+	return args, nil
+}
+
+// parse_type_params parses the type parameters of a generic function or type,
+// [T, U], into a chain of NodeParam each holding an identifier.
+func (t *Parser) parse_type_params() (*Node, error) {
+	c := t.consume() // [
+	var params, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rbracket {
+		if params != nil {
+			if t.peek().Type != tokeniser.Comma {
+				return nil, ParseError("expected ','", t.peek())
+			}
+			t.consume()
+		}
+		if t.peek().Type != tokeniser.Identifier {
+			return nil, ParseError("expected type parameter", t.peek())
+		}
+		id := t.consume()
+
+		param := &Node{Type: NodeParam, Lhs: &Node{Type: NodeIdentifier, Value: id.Value, Line: id.Line, Col: id.Col}}
+		if params == nil {
+			params = param
+		} else {
+			tail.Rhs = param
+		}
+		tail = param
+	}
+	if t.peek() == nil || params == nil {
+		return nil, ParseError("expected type parameter", c)
+	}
+	t.consume() // ]
+	return params, nil
+}
+
+// generic wraps the declaration of a generic function or type in a NodeGeneric
+// with its type parameters in Lhs, if it has any.
+func generic(params *Node, decl *Node) *Node {
+	if params == nil {
+		return decl
+	}
+	return &Node{Type: NodeGeneric, Value: decl.Value, Lhs: params, Rhs: decl, Line: decl.Line, Col: decl.Col}
+}
+
+// parse_function parses a function declaration. The parameters are a chain of
+// NodeParam in Lhs, each an identifier with its type in Lhs, the result types
+// are a chain of NodeParam in Rhs and the body is in Stmts. Generic functions,
+// fn max[T](a T, b T) T, are wrapped in a NodeGeneric.
+func (t *Parser) parse_function() (*Node, error) {
+	fn, err := t.parse_signature()
+	if err != nil {
+		return nil, err
+	}
+	decl := fn
+	if fn.Type == NodeGeneric {
+		decl = fn.Rhs
+	}
+	decl.Stmts, err = t.parse_scope()
+	if err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+// parse_signature parses a function declaration up to its body.
+func (t *Parser) parse_signature() (*Node, error) {
+	c := t.consume() // fn
+	if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+		return nil, ParseError("expected function name", c)
+	}
+	name := t.consume()
+	var type_params *Node
+	if t.peek() != nil && t.peek().Type == tokeniser.Lbracket {
+		var err error
+		type_params, err = t.parse_type_params()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Lparen {
+		return nil, ParseError("expected '('", name)
+	}
+	t.consume()
+
+	var params, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rparen {
+		if params != nil {
+			if t.peek().Type != tokeniser.Comma {
+				return nil, ParseError("expected ','", t.peek())
+			}
+			t.consume()
+		}
+		if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+			return nil, ParseError("expected parameter name", name)
+		}
+		c := t.consume()
+		id := &Node{Type: NodeIdentifier, Value: c.Value, Line: c.Line, Col: c.Col}
+		if c.Value == "self" && params == nil && t.peek() != nil && (t.peek().Type == tokeniser.Comma || t.peek().Type == tokeniser.Rparen) {
+			// the receiver of a method, its type is filled in by parse_impl
+		} else if t.peek() != nil && t.peek().Type == tokeniser.Ellipsis {
+			// the rest of the arguments, as a slice
+			dots := t.consume()
+			ty, err := t.parse_type()
+			if err != nil {
+				return nil, err
+			}
+			if t.peek() == nil || t.peek().Type != tokeniser.Rparen {
+				return nil, ParseError("only the last parameter can be variadic", dots)
+			}
+			id.Lhs = &Node{Type: NodeVariadicType, Lhs: ty, Line: dots.Line, Col: dots.Col}
+		} else {
+			ty, err := t.parse_type()
+			if err != nil {
+				return nil, err
+			}
+			id.Lhs = ty
+		}
+
+		param := &Node{Type: NodeParam, Lhs: id}
+		if params == nil {
+			params = param
+		} else {
+			tail.Rhs = param
+		}
+		tail = param
+	}
+	if t.peek() == nil {
+		return nil, fmt.Errorf("unexpected EOF")
+	}
+	t.consume() // )
+
+	var results *Node
+	if t.peek() != nil && t.peek().Type == tokeniser.Lparen {
+		t.consume()
+		for tail = nil; t.peek() != nil && t.peek().Type != tokeniser.Rparen; {
+			if results != nil {
+				if t.peek().Type != tokeniser.Comma {
+					return nil, ParseError("expected ','", t.peek())
+				}
+				t.consume()
+			}
+			ty, err := t.parse_type()
+			if err != nil {
+				return nil, err
+			}
+
+			result := &Node{Type: NodeParam, Lhs: ty}
+			if results == nil {
+				results = result
+			} else {
+				tail.Rhs = result
+			}
+			tail = result
+		}
+		if t.peek() == nil {
+			return nil, fmt.Errorf("unexpected EOF")
+		}
+		t.consume() // )
+	} else if t.peek() != nil && t.peek().Type != tokeniser.Lcurly && t.peek().Type != tokeniser.Rcurly && t.peek().Type != tokeniser.Fn {
+		// the signatures in an interface are followed by the next one or the end
+		ty, err := t.parse_type()
+		if err != nil {
+			return nil, err
+		}
+		results = &Node{Type: NodeParam, Lhs: ty}
+	}
+	return generic(type_params, &Node{Type: NodeFunction, Value: name.Value, Lhs: params, Rhs: results, Line: name.Line, Col: name.Col}), nil
+}
+
+// parse_targets parses the rest of a comma separated list of identifiers on
+// the left of an assignment, into a NodeTuple with a chain of NodeParam.
+func (t *Parser) parse_targets(first *Node) (*Node, error) {
+	head := &Node{Type: NodeParam, Lhs: first}
+	tail := head
+	for t.peek() != nil && t.peek().Type == tokeniser.Comma {
+		c := t.consume()
+		if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+			return nil, ParseError("expected identifier", c)
+		}
+		id := t.consume()
+		tail.Rhs = &Node{Type: NodeParam, Lhs: &Node{Type: NodeIdentifier, Value: id.Value, Line: id.Line, Col: id.Col}}
+		tail = tail.Rhs
+	}
+	return &Node{Type: NodeTuple, Lhs: head}, nil
+}
+
+// parse_args parses the arguments of a call, between parentheses, into a chain
+// of NodeParam.
+func (t *Parser) parse_args(id *tokeniser.Token) (*Node, error) {
+	t.consume() // (
+	var args *Node
+	if t.peek() != nil && t.peek().Type != tokeniser.Rparen {
+		params, err := t.parse_params()
+		if err != nil {
+			return nil, err
+		}
+		args = params
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Rparen {
+		return nil, ParseError("expected ')'", id)
+	}
+	t.consume()
+	return args, nil
+}
+
 func (t *Parser) parse_identifier() (*Node, error) {
 	id := t.consume()
 	if t.peek() != nil && t.peek().Type == tokeniser.Lparen {
+		args, err := t.parse_args(id)
+		if err != nil {
+			return nil, err
+		}
+		return t.parse_index(&Node{Type: NodeCall, Value: id.Value, Rhs: args, Line: id.Line, Col: id.Col})
+	}
+	if t.is_struct_literal(t.index) {
+		return t.parse_struct_literal(id, nil)
+	}
+	if t.is_generic_literal() {
+		args, err := t.parse_type_args()
+		if err != nil {
+			return nil, err
+		}
+		return t.parse_struct_literal(id, args)
+	}
+
+	return t.parse_index(&Node{
+		Type:  NodeIdentifier,
+		Value: id.Value,
+		Line:  id.Line,
+		Col:   id.Col,
+	})
+}
+
+// parse_index parses any indexes, s[i], sub-slices, s[a:b], fields, p.x, or
+// method calls, p.len(), following a term. Either end of a sub-slice can be
+// left out.
+func (t *Parser) parse_index(target *Node) (*Node, error) {
+	for t.peek() != nil && (t.peek().Type == tokeniser.Lbracket || t.peek().Type == tokeniser.Dot) {
+		c := t.consume()
+		if c.Type == tokeniser.Dot {
+			if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+				return nil, ParseError("expected field or method name", c)
+			}
+			name := t.consume()
+			if t.peek() != nil && t.peek().Type == tokeniser.Lparen {
+				args, err := t.parse_args(name)
+				if err != nil {
+					return nil, err
+				}
+				target = &Node{Type: NodeMethodCall, Value: name.Value, Lhs: target, Rhs: args, Line: name.Line, Col: name.Col}
+			} else {
+				target = &Node{Type: NodeField, Value: name.Value, Lhs: target, Line: name.Line, Col: name.Col}
+			}
+			continue
+		}
+
+		var low, high *Node
+		var err error
+		if t.peek() != nil && t.peek().Type != tokeniser.Colon {
+			low, err = t.parse_expr(0)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if t.peek() != nil && t.peek().Type == tokeniser.Colon {
+			t.consume()
+			if t.peek() != nil && t.peek().Type != tokeniser.Rbracket {
+				high, err = t.parse_expr(0)
+				if err != nil {
+					return nil, err
+				}
+			}
+			target = &Node{Type: NodeSlice, Lhs: target, Rhs: &Node{Type: NodeRange, Lhs: low, Rhs: high}, Line: c.Line, Col: c.Col}
+		} else {
+			if low == nil {
+				return nil, ParseError("expected index", c)
+			}
+			target = &Node{Type: NodeIndex, Lhs: target, Rhs: low, Line: c.Line, Col: c.Col}
+		}
+
+		if t.peek() == nil || t.peek().Type != tokeniser.Rbracket {
+			return nil, ParseError("expected ']'", c)
+		}
+		t.consume()
+	}
+	return target, nil
+}
+
+// is_struct_literal looks ahead from token i for the start of a struct literal
+// following a type name, either {} or { field:, so that it isn't mistaken for
+// the scope after the test of an if or for.
+func (t *Parser) is_struct_literal(i int) bool {
+	if i+1 >= len(t.Tokens) || t.Tokens[i].Type != tokeniser.Lcurly {
+		return false
+	}
+	if t.Tokens[i+1].Type == tokeniser.Rcurly {
+		return true
+	}
+	return i+2 < len(t.Tokens) && t.Tokens[i+1].Type == tokeniser.Identifier && t.Tokens[i+2].Type == tokeniser.Colon
+}
+
+// is_generic_literal looks ahead for the type arguments of a generic struct
+// literal, Stack[int]{}, which would otherwise be parsed as an index.
+func (t *Parser) is_generic_literal() bool {
+	if t.peek() == nil || t.peek().Type != tokeniser.Lbracket {
+		return false
+	}
+	depth := 0
+	for i := t.index; i < len(t.Tokens); i++ {
+		switch t.Tokens[i].Type {
+		case tokeniser.Lbracket:
+			depth++
+		case tokeniser.Rbracket:
+			depth--
+			if depth == 0 {
+				return t.is_struct_literal(i + 1)
+			}
+		case tokeniser.Identifier, tokeniser.Comma, tokeniser.Map:
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// parse_struct_literal parses Point{x: 1, y: 2} into a NodeStructLiteral with
+// the name of the struct as its value, any type arguments in Lhs and a chain of
+// NodeParam in Rhs, each holding a NodeEntry with the field in Lhs and its
+// value in Rhs.
+func (t *Parser) parse_struct_literal(id *tokeniser.Token, args *Node) (*Node, error) {
+	t.consume() // {
+	var head, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if head != nil {
+			if t.peek().Type != tokeniser.Comma {
+				return nil, ParseError("expected ','", t.peek())
+			}
+			t.consume()
+		}
+		if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+			return nil, ParseError("expected field name", id)
+		}
+		field := t.consume()
+		if t.peek() == nil || t.peek().Type != tokeniser.Colon {
+			return nil, ParseError("expected ':'", field)
+		}
+		t.consume()
+		value, err := t.parse_expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, ParseError("expected value", field)
+		}
+
+		entry := &Node{Type: NodeEntry, Lhs: &Node{Type: NodeIdentifier, Value: field.Value, Line: field.Line, Col: field.Col}, Rhs: value}
+		param := &Node{Type: NodeParam, Lhs: entry}
+		if head == nil {
+			head = param
+		} else {
+			tail.Rhs = param
+		}
+		tail = param
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", id)
+	}
+	t.consume()
+	return t.parse_index(&Node{Type: NodeStructLiteral, Value: id.Value, Lhs: args, Rhs: head, Line: id.Line, Col: id.Col})
+}
+
+// parse_struct parses a struct declaration into a NodeStruct with a chain of
+// NodeParam fields in Lhs, each an identifier with its type in Lhs. Fields can
+// be separated by commas or just new lines. Generic structs, struct Stack[T],
+// are wrapped in a NodeGeneric.
+func (t *Parser) parse_struct() (*Node, error) {
+	c := t.consume() // struct
+	if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+		return nil, ParseError("expected struct name", c)
+	}
+	name := t.consume()
+	var type_params *Node
+	if t.peek() != nil && t.peek().Type == tokeniser.Lbracket {
+		var err error
+		type_params, err = t.parse_type_params()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", name)
+	}
+	t.consume()
+
+	var fields, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if t.peek().Type == tokeniser.Comma && fields != nil {
+			t.consume()
+		}
+		if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+			return nil, ParseError("expected field name", name)
+		}
+		f := t.consume()
+		ty, err := t.parse_type()
+		if err != nil {
+			return nil, err
+		}
+
+		field := &Node{Type: NodeParam, Lhs: &Node{Type: NodeIdentifier, Value: f.Value, Lhs: ty, Line: f.Line, Col: f.Col}}
+		if fields == nil {
+			fields = field
+		} else {
+			tail.Rhs = field
+		}
+		tail = field
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", name)
+	}
+	t.consume()
+	return generic(type_params, &Node{Type: NodeStruct, Value: name.Value, Lhs: fields, Line: name.Line, Col: name.Col}), nil
+}
+
+// parse_union parses a union declaration, union Token { Int(int), Eof }, into
+// a NodeUnion with a chain of NodeParam in Lhs, each holding a NodeVariant with
+// the types of its values as a chain of NodeParam in Lhs. Like fields, variants
+// can be separated by commas or just new lines.
+func (t *Parser) parse_union() (*Node, error) {
+	c := t.consume() // union
+	if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+		return nil, ParseError("expected union name", c)
+	}
+	name := t.consume()
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", name)
+	}
+	t.consume()
+
+	var variants, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if t.peek().Type == tokeniser.Comma && variants != nil {
+			t.consume()
+		}
+		if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+			return nil, ParseError("expected variant name", name)
+		}
+		v := t.consume()
+
+		var types, last *Node
+		if t.peek() != nil && t.peek().Type == tokeniser.Lparen {
+			t.consume()
+			for t.peek() != nil && t.peek().Type != tokeniser.Rparen {
+				if types != nil {
+					if t.peek().Type != tokeniser.Comma {
+						return nil, ParseError("expected ','", t.peek())
+					}
+					t.consume()
+				}
+				ty, err := t.parse_type()
+				if err != nil {
+					return nil, err
+				}
+				param := &Node{Type: NodeParam, Lhs: ty}
+				if types == nil {
+					types = param
+				} else {
+					last.Rhs = param
+				}
+				last = param
+			}
+			if t.peek() == nil {
+				return nil, ParseError("expected ')'", v)
+			}
+			t.consume()
+		}
+
+		variant := &Node{Type: NodeParam, Lhs: &Node{Type: NodeVariant, Value: v.Value, Lhs: types, Line: v.Line, Col: v.Col}}
+		if variants == nil {
+			variants = variant
+		} else {
+			tail.Rhs = variant
+		}
+		tail = variant
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", name)
+	}
+	t.consume()
+	if variants == nil {
+		return nil, ParseError("expected at least one variant in union", name)
+	}
+	return &Node{Type: NodeUnion, Value: name.Value, Lhs: variants, Line: name.Line, Col: name.Col}, nil
+}
+
+// parse_impl parses the methods of a type, impl Point { fn ... }, into a
+// NodeImpl with the type name as its value and the functions in Stmts. The
+// methods of generic types, impl Stack[T], are wrapped in a NodeGeneric.
+func (t *Parser) parse_impl() (*Node, error) {
+	c := t.consume() // impl
+	if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+		return nil, ParseError("expected type name", c)
+	}
+	name := t.consume()
+	self := &Node{Type: NodeTypeName, Value: name.Value}
+	var type_params *Node
+	if t.peek() != nil && t.peek().Type == tokeniser.Lbracket {
+		var err error
+		type_params, err = t.parse_type_params()
+		if err != nil {
+			return nil, err
+		}
+		// self is the generic type with its own parameters, Stack[T]
+		var tail *Node
+		for param := type_params; param != nil; param = param.Rhs {
+			arg := &Node{Type: NodeParam, Lhs: &Node{Type: NodeTypeName, Value: param.Lhs.Value}}
+			if self.Lhs == nil {
+				self.Lhs = arg
+			} else {
+				tail.Rhs = arg
+			}
+			tail = arg
+		}
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", name)
+	}
+	t.consume()
+
+	methods := StatementSequence{}
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if t.peek().Type != tokeniser.Fn {
+			return nil, ParseError("expected method", t.peek())
+		}
+		c := t.peek()
+		method, err := t.parse_stmt()
+		if err != nil {
+			return nil, err
+		}
+		if method.Type == NodeGeneric {
+			return nil, ParseError("methods can't have type parameters", c)
+		}
+		if method.Lhs == nil || method.Lhs.Lhs.Value != "self" {
+			return nil, ParseError("expected self as the first parameter of method", &t.Tokens[t.index-1])
+		}
+		method.Lhs.Lhs.Lhs = self
+		methods.append(method)
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", name)
+	}
+	t.consume()
+	return generic(type_params, &Node{Type: NodeImpl, Value: name.Value, Stmts: &methods, Line: name.Line, Col: name.Col}), nil
+}
+
+// parse_if_let parses the let v = maybe of an if let into a NodeLet, with the
+// variable in Lhs and the optional in Rhs.
+func (t *Parser) parse_if_let() (*Node, error) {
+	c := t.consume() // let
+	if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+		return nil, ParseError("expected identifier", c)
+	}
+	id := t.consume()
+	if t.peek() == nil || t.peek().Type != tokeniser.Assign {
+		return nil, ParseError("expected '='", id)
+	}
+	t.consume()
+	value, err := t.parse_expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ParseError("expected expression", id)
+	}
+	return &Node{Type: NodeLet, Value: "let", Lhs: &Node{Type: NodeIdentifier, Value: id.Value, Line: id.Line, Col: id.Col}, Rhs: value, Line: c.Line, Col: c.Col}, nil
+}
+
+// parse_match parses a match statement into a NodeMatch with the value being
+// matched in Lhs and a chain of NodeParam in Rhs, each holding a NodeArm with
+// its pattern in Lhs, its guard, if it has one, in Rhs and its body in Stmts.
+// The body of an arm is either a scope or a single statement, and arms can be
+// separated by commas.
+func (t *Parser) parse_match() (*Node, error) {
+	c := t.consume() // match
+	value, err := t.parse_expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ParseError("expected expression", c)
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", c)
+	}
+	t.consume()
+
+	var arms, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if t.peek().Type == tokeniser.Comma && arms != nil {
+			t.consume()
+			continue
+		}
+		start := t.peek()
+		pattern, err := t.parse_pattern()
+		if err != nil {
+			return nil, err
+		}
+		var guard *Node
+		if t.peek() != nil && t.peek().Type == tokeniser.If {
+			t.consume()
+			guard, err = t.parse_test()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if t.peek() == nil || t.peek().Type != tokeniser.Arrow {
+			return nil, ParseError("expected '=>'", start)
+		}
+		t.consume()
+
+		var body *StatementSequence
+		if t.peek() != nil && t.peek().Type == tokeniser.Lcurly {
+			body, err = t.parse_scope()
+		} else {
+			var stmt *Node
+			stmt, err = t.parse_stmt()
+			body = &StatementSequence{}
+			if stmt != nil {
+				body.append(stmt)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		arm := &Node{Type: NodeParam, Lhs: &Node{Type: NodeArm, Lhs: pattern, Rhs: guard, Stmts: body, Line: start.Line, Col: start.Col}}
+		if arms == nil {
+			arms = arm
+		} else {
+			tail.Rhs = arm
+		}
+		tail = arm
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", c)
+	}
+	t.consume()
+	if arms == nil {
+		return nil, ParseError("expected at least one arm in match", c)
+	}
+	return &Node{Type: NodeMatch, Lhs: value, Rhs: arms, Line: c.Line, Col: c.Col}, nil
+}
+
+// is_for_in reports whether the for loop about to be parsed is a for in loop,
+// which starts with its targets rather than a test.
+func (t *Parser) is_for_in() bool {
+	if t.index+2 >= len(t.Tokens) || t.Tokens[t.index+1].Type != tokeniser.Identifier {
+		return false
+	}
+	next := t.Tokens[t.index+2].Type
+	return next == tokeniser.In || next == tokeniser.Comma
+}
+
+// parse_for_in parses a loop over the values of a collection, for x in xs or
+// for i, x in xs, into a NodeForIn with the targets as a NodeTuple in Lhs and
+// the collection in Rhs.
+func (t *Parser) parse_for_in() (*Node, error) {
+	c := t.consume() // for
+	id := t.consume()
+	targets, err := t.parse_targets(&Node{Type: NodeIdentifier, Value: id.Value, Line: id.Line, Col: id.Col})
+	if err != nil {
+		return nil, err
+	}
+	if targets.Lhs.Rhs != nil && targets.Lhs.Rhs.Rhs != nil {
+		return nil, ParseError("expected at most two variables in for", c)
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.In {
+		return nil, ParseError("expected 'in'", c)
+	}
+	in := t.consume()
+	value, err := t.parse_expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ParseError("expected expression", in)
+	}
+	stmts, err := t.parse_scope()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Type: NodeForIn, Lhs: targets, Rhs: value, Stmts: stmts, Line: c.Line, Col: c.Col}, nil
+}
+
+// parse_pattern parses the pattern of a match arm or let. It's either none, an
+// int, an identifier, which matches anything and binds it unless it's _, a
+// variant with patterns for its values, Some(v), as a NodeVariant with a chain
+// of NodeParam in Lhs, or a struct with patterns for some of its fields.
+func (t *Parser) parse_pattern() (*Node, error) {
+	tok := t.peek()
+	if tok.Type == tokeniser.None {
 		t.consume()
-		var rhs *Node
-		for t.peek().Type != tokeniser.Rparen {
-			value, err := t.parse_expr(0)
+		return &Node{Type: NodeNone, Line: tok.Line, Col: tok.Col}, nil
+	}
+	if tok.Type == tokeniser.Int {
+		t.consume()
+		return &Node{Type: NodeIntLiteral, Value: tok.Value, Line: tok.Line, Col: tok.Col}, nil
+	}
+	if tok.Type != tokeniser.Identifier {
+		return nil, ParseError("expected pattern", tok)
+	}
+	t.consume()
+	if t.peek() != nil && t.peek().Type == tokeniser.Lcurly {
+		return t.parse_struct_pattern(tok)
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Lparen {
+		return &Node{Type: NodeIdentifier, Value: tok.Value, Line: tok.Line, Col: tok.Col}, nil
+	}
+	c := t.consume() // (
+
+	var values, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rparen {
+		if values != nil {
+			if t.peek().Type != tokeniser.Comma {
+				return nil, ParseError("expected ','", t.peek())
+			}
+			t.consume()
+		}
+		if t.peek() == nil {
+			break
+		}
+		value, err := t.parse_pattern()
+		if err != nil {
+			return nil, err
+		}
+
+		param := &Node{Type: NodeParam, Lhs: value}
+		if values == nil {
+			values = param
+		} else {
+			tail.Rhs = param
+		}
+		tail = param
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected ')'", c)
+	}
+	t.consume()
+	return &Node{Type: NodeVariant, Value: tok.Value, Lhs: values, Line: tok.Line, Col: tok.Col}, nil
+}
+
+// parse_struct_pattern parses the fields of a struct pattern, Point{x, y: 0},
+// into a NodeStructPattern with a chain of NodeParam in Rhs, each holding a
+// NodeField with the pattern for the field in Lhs. A field without a pattern
+// is bound to a variable of the same name.
+func (t *Parser) parse_struct_pattern(name *tokeniser.Token) (*Node, error) {
+	c := t.consume() // {
+	var fields, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if fields != nil {
+			if t.peek().Type != tokeniser.Comma {
+				return nil, ParseError("expected ','", t.peek())
+			}
+			t.consume()
+		}
+		if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+			return nil, ParseError("expected field name", c)
+		}
+		f := t.consume()
+		value := &Node{Type: NodeIdentifier, Value: f.Value, Line: f.Line, Col: f.Col}
+		if t.peek() != nil && t.peek().Type == tokeniser.Colon {
+			t.consume()
+			if t.peek() == nil {
+				return nil, ParseError("expected pattern", f)
+			}
+			var err error
+			value, err = t.parse_pattern()
 			if err != nil {
 				return nil, err
 			}
-			rhs = &Node{Type: NodeParam, Lhs: value}
+		}
+
+		field := &Node{Type: NodeParam, Lhs: &Node{Type: NodeField, Value: f.Value, Lhs: value, Line: f.Line, Col: f.Col}}
+		if fields == nil {
+			fields = field
+		} else {
+			tail.Rhs = field
+		}
+		tail = field
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", c)
+	}
+	t.consume()
+	return &Node{Type: NodeStructPattern, Value: name.Value, Rhs: fields, Line: name.Line, Col: name.Col}, nil
+}
+
+// parse_interface parses the methods a type needs to implement an interface,
+// interface Writer { fn write(self, s string) }, into a NodeInterface with the
+// interface name as its value and the method signatures in Stmts.
+func (t *Parser) parse_interface() (*Node, error) {
+	c := t.consume() // interface
+	if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+		return nil, ParseError("expected interface name", c)
+	}
+	name := t.consume()
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", name)
+	}
+	t.consume()
+
+	methods := StatementSequence{}
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if t.peek().Type != tokeniser.Fn {
+			return nil, ParseError("expected method", t.peek())
+		}
+		c := t.peek()
+		method, err := t.parse_signature()
+		if err != nil {
+			return nil, err
+		}
+		if method.Type == NodeGeneric {
+			return nil, ParseError("methods can't have type parameters", c)
+		}
+		if method.Lhs == nil || method.Lhs.Lhs.Value != "self" || method.Lhs.Lhs.Lhs != nil {
+			return nil, ParseError("expected self as the first parameter of method", c)
+		}
+		method.Lhs.Lhs.Lhs = &Node{Type: NodeTypeName, Value: name.Value}
+		method.Line, method.Col = c.Line, c.Col
+		methods.append(method)
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", name)
+	}
+	t.consume()
+	return &Node{Type: NodeInterface, Value: name.Value, Stmts: &methods, Line: name.Line, Col: name.Col}, nil
+}
+
+// parse_slice_literal parses []T{a, b, c} into a NodeSliceLiteral with the
+// slice type in Lhs and a chain of NodeParam elements in Rhs.
+func (t *Parser) parse_slice_literal() (*Node, error) {
+	c := t.peek()
+	ty, err := t.parse_type()
+	if err != nil {
+		return nil, err
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", c)
+	}
+	t.consume()
+
+	var elems *Node
+	if t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		elems, err = t.parse_params()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Rcurly {
+		return nil, ParseError("expected '}'", c)
+	}
+	t.consume()
+	return t.parse_index(&Node{Type: NodeSliceLiteral, Lhs: ty, Rhs: elems, Line: c.Line, Col: c.Col})
+}
+
+// parse_map_literal parses map[K]V{k: v, ...} into a NodeMapLiteral with the
+// map type in Lhs and a chain of NodeParam in Rhs, each holding a NodeEntry
+// with the key in Lhs and the value in Rhs.
+func (t *Parser) parse_map_literal() (*Node, error) {
+	c := t.peek()
+	ty, err := t.parse_type()
+	if err != nil {
+		return nil, err
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", c)
+	}
+	t.consume()
+
+	var head, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+		if head != nil {
+			if t.peek().Type != tokeniser.Comma {
+				return nil, ParseError("expected ','", t.peek())
+			}
+			t.consume()
+		}
+		key, err := t.parse_expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil || t.peek() == nil || t.peek().Type != tokeniser.Colon {
+			return nil, ParseError("expected key and ':'", c)
 		}
 		t.consume()
-		return &Node{Type: NodeCall, Value: id.Value, Rhs: rhs}, nil
+		value, err := t.parse_expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, ParseError("expected value", c)
+		}
+
+		param := &Node{Type: NodeParam, Lhs: &Node{Type: NodeEntry, Lhs: key, Rhs: value}}
+		if head == nil {
+			head = param
+		} else {
+			tail.Rhs = param
+		}
+		tail = param
+	}
+	if t.peek() == nil {
+		return nil, ParseError("expected '}'", c)
 	}
+	t.consume()
+	return t.parse_index(&Node{Type: NodeMapLiteral, Lhs: ty, Rhs: head, Line: c.Line, Col: c.Col})
+}
 
-	return &Node{
-		Type:  NodeIdentifier,
-		Value: id.Value,
-	}, nil
+// parse_if_expr parses if used as a value, if test { a } else { b }, into a
+// NodeIfExpr with the test in Lhs and a NodeBranches with the value of each
+// branch in Rhs. The else branch can be another if.
+func (t *Parser) parse_if_expr() (*Node, error) {
+	c := t.consume() // if
+	test, err := t.parse_test()
+	if err != nil {
+		return nil, err
+	}
+	then, err := t.parse_branch(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.peek() == nil || t.peek().Type != tokeniser.Else {
+		return nil, ParseError("expected else, as if used as a value needs both branches", c)
+	}
+	t.consume()
+	var otherwise *Node
+	if t.peek() != nil && t.peek().Type == tokeniser.If {
+		otherwise, err = t.parse_if_expr()
+	} else {
+		otherwise, err = t.parse_branch(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Type: NodeIfExpr, Lhs: test, Rhs: &Node{Type: NodeBranches, Lhs: then, Rhs: otherwise}, Line: c.Line, Col: c.Col}, nil
+}
+
+// parse_branch parses the value of a branch of an if expression, { value }.
+func (t *Parser) parse_branch(c *tokeniser.Token) (*Node, error) {
+	if t.peek() == nil || t.peek().Type != tokeniser.Lcurly {
+		return nil, ParseError("expected '{'", c)
+	}
+	t.consume()
+	value, err := t.parse_expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ParseError("expected value in branch of if", c)
+	}
+	if t.peek() == nil || t.peek().Type != tokeniser.Rcurly {
+		return nil, ParseError("expected '}'", c)
+	}
+	t.consume()
+	return value, nil
+}
+
+// parse_interpolation parses an interpolated string into a NodeInterpolate
+// with a chain of NodeParam parts, which are either string literals or the
+// expressions between curly braces.
+func (t *Parser) parse_interpolation() (*Node, error) {
+	start := t.consume()
+	var head, tail *Node
+	for t.peek() != nil && t.peek().Type != tokeniser.InterpolateEnd {
+		var part *Node
+		switch t.peek().Type {
+		case tokeniser.String:
+			part = &Node{Type: NodeStringLiteral, Value: t.consume().Value}
+		case tokeniser.Lcurly:
+			c := t.consume()
+			expr, err := t.parse_expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if expr == nil {
+				return nil, ParseError("expected expression in string", c)
+			}
+			if t.peek() == nil || t.peek().Type != tokeniser.Rcurly {
+				return nil, ParseError("expected '}' in string", c)
+			}
+			t.consume()
+			part = expr
+		default:
+			return nil, ParseError("unexpected token in string", t.peek())
+		}
+
+		param := &Node{Type: NodeParam, Lhs: part}
+		if head == nil {
+			head = param
+		} else {
+			tail.Rhs = param
+		}
+		tail = param
+	}
+
+	if t.peek() == nil {
+		return nil, fmt.Errorf("unexpected EOF")
+	}
+	t.consume()
+	return &Node{Type: NodeInterpolate, Lhs: head, Line: start.Line, Col: start.Col}, nil
 }
 
 func (t *Parser) parse_term() (*Node, error) {
@@ -107,14 +1264,39 @@ func (t *Parser) parse_term() (*Node, error) {
 		return &Node{
 			Type:  NodeIntLiteral,
 			Value: t.consume().Value,
+			Line:  tok.Line,
+			Col:   tok.Col,
 		}, nil
 	case tokeniser.String:
 		return &Node{
 			Type:  NodeStringLiteral,
 			Value: t.consume().Value,
+			Line:  tok.Line,
+			Col:   tok.Col,
 		}, nil
 	case tokeniser.Identifier:
 		return t.parse_identifier()
+	case tokeniser.InterpolateStart:
+		return t.parse_interpolation()
+	case tokeniser.Lbracket:
+		return t.parse_slice_literal()
+	case tokeniser.Map:
+		return t.parse_map_literal()
+	case tokeniser.If:
+		return t.parse_if_expr()
+	case tokeniser.None:
+		t.consume()
+		return &Node{Type: NodeNone, Line: tok.Line, Col: tok.Col}, nil
+	case tokeniser.Try:
+		c := t.consume()
+		call, err := t.parse_term()
+		if err != nil {
+			return nil, err
+		}
+		if call == nil || (call.Type != NodeCall && call.Type != NodeMethodCall) {
+			return nil, ParseError("expected function call after try", c)
+		}
+		return &Node{Type: NodeTry, Lhs: call, Line: c.Line, Col: c.Col}, nil
 	case tokeniser.Lparen:
 		t.consume()
 		expr, err := t.parse_expr(0)
@@ -141,7 +1323,7 @@ func get_operator_prec(op tokeniser.TokenType) *int {
 	switch op {
 	case tokeniser.Plus, tokeniser.Minus:
 		prec = 0
-	case tokeniser.Star, tokeniser.Fslash:
+	case tokeniser.Star, tokeniser.Fslash, tokeniser.Percent:
 		prec = 1
 	default:
 		return nil
@@ -165,7 +1347,7 @@ func (t *Parser) parse_test() (*Node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node := Node{Type: NodeGt, Lhs: test, Rhs: rhs}
+			node := Node{Type: NodeGt, Lhs: test, Rhs: rhs, Line: tok.Line, Col: tok.Col}
 			test = &node
 		case tokeniser.Lt:
 			t.consume()
@@ -173,7 +1355,7 @@ func (t *Parser) parse_test() (*Node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node := Node{Type: NodeLt, Lhs: test, Rhs: rhs}
+			node := Node{Type: NodeLt, Lhs: test, Rhs: rhs, Line: tok.Line, Col: tok.Col}
 			test = &node
 		case tokeniser.Eq:
 			t.consume()
@@ -181,7 +1363,7 @@ func (t *Parser) parse_test() (*Node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node := Node{Type: NodeEq, Lhs: test, Rhs: rhs}
+			node := Node{Type: NodeEq, Lhs: test, Rhs: rhs, Line: tok.Line, Col: tok.Col}
 			test = &node
 		default:
 			node := Node{Type: NodeGt, Lhs: test, Rhs: &Node{Type: NodeIntLiteral, Value: "0"}}
@@ -219,7 +1401,7 @@ func (t *Parser) parse_expr(min_prec int) (*Node, error) {
 		if rhs == nil {
 			return nil, ParseError("invalid expression", op)
 		}
-		expr2 := Node{Lhs: expr, Rhs: rhs}
+		expr2 := Node{Lhs: expr, Rhs: rhs, Line: op.Line, Col: op.Col}
 		if op.Type == tokeniser.Plus {
 			expr2.Type = NodeAdd
 		} else if op.Type == tokeniser.Minus {
@@ -228,6 +1410,8 @@ func (t *Parser) parse_expr(min_prec int) (*Node, error) {
 			expr2.Type = NodeMulti
 		} else if op.Type == tokeniser.Fslash {
 			expr2.Type = NodeDiv
+		} else if op.Type == tokeniser.Percent {
+			expr2.Type = NodeMod
 		} else {
 			panic(fmt.Sprintf("Unreachable, this should not happen (see prec check above): token type %d", op.Type))
 		}
@@ -237,6 +1421,17 @@ func (t *Parser) parse_expr(min_prec int) (*Node, error) {
 }
 
 func (t *Parser) parse_stmt() (*Node, error) {
+	tok := t.peek()
+	stmt, err := t.parse_statement()
+	// statements are at their first token, except compound assignments,
+	// which are at their operator so that checked arithmetic reports it
+	if stmt != nil && (stmt.Type != NodeAssign || stmt.Value == "") {
+		stmt.Line, stmt.Col = tok.Line, tok.Col
+	}
+	return stmt, err
+}
+
+func (t *Parser) parse_statement() (*Node, error) {
 	if t.peek() == nil {
 		return nil, errors.New("no more tokens left")
 	}
@@ -251,14 +1446,52 @@ func (t *Parser) parse_stmt() (*Node, error) {
 		}
 		return &Node{Type: NodeExit, Lhs: lhs}, nil
 
-	case tokeniser.Let:
+	case tokeniser.Let, tokeniser.Var:
 		c := t.consume() // let
-		if t.peek() != nil && t.peek().Type != tokeniser.Identifier {
-			return nil, ParseError("expected identifier", c)
+		binding := "let"
+		if c.Type == tokeniser.Var {
+			binding = "var"
 		}
+		var lhs *Node
+		if t.peek() != nil && t.peek().Type == tokeniser.Lparen {
+			// let (x, y) = f() is the same as let x, y = f()
+			t.consume()
+			if t.peek() == nil || t.peek().Type != tokeniser.Identifier {
+				return nil, ParseError("expected identifier", c)
+			}
+			c = t.consume()
+			tuple, err := t.parse_targets(&Node{Type: NodeIdentifier, Value: c.Value, Line: c.Line, Col: c.Col})
+			if err != nil {
+				return nil, err
+			}
+			if t.peek() == nil || t.peek().Type != tokeniser.Rparen {
+				return nil, ParseError("expected ')'", c)
+			}
+			t.consume()
+			lhs = tuple
+		} else if t.peek() != nil && t.peek().Type == tokeniser.Identifier && t.index+1 < len(t.Tokens) && (t.Tokens[t.index+1].Type == tokeniser.Lcurly || t.Tokens[t.index+1].Type == tokeniser.Lparen) {
+			// let Point{x, y} = p
+			c = t.peek()
+			pattern, err := t.parse_pattern()
+			if err != nil {
+				return nil, err
+			}
+			lhs = pattern
+		} else {
+			if t.peek() != nil && t.peek().Type != tokeniser.Identifier {
+				return nil, ParseError("expected identifier", c)
+			}
 
-		c = t.consume()
-		lhs := Node{Type: NodeIdentifier, Value: c.Value} // x
+			c = t.consume()
+			lhs = &Node{Type: NodeIdentifier, Value: c.Value, Line: c.Line, Col: c.Col} // x
+			if t.peek() != nil && t.peek().Type == tokeniser.Comma {
+				tuple, err := t.parse_targets(lhs) // x, y
+				if err != nil {
+					return nil, err
+				}
+				lhs = tuple
+			}
+		}
 		if t.peek() != nil && t.peek().Type != tokeniser.Assign {
 			return nil, ParseError("expected '='", c)
 		}
@@ -269,7 +1502,7 @@ func (t *Parser) parse_stmt() (*Node, error) {
 			return nil, err
 		}
 
-		return &Node{Type: NodeLet, Lhs: &lhs, Rhs: rhs}, nil
+		return &Node{Type: NodeLet, Value: binding, Lhs: lhs, Rhs: rhs}, nil
 
 	case tokeniser.Lcurly:
 		stmts, _ := t.parse_scope()
@@ -278,7 +1511,16 @@ func (t *Parser) parse_stmt() (*Node, error) {
 	case tokeniser.If:
 		t.consume()
 
-		lhs, err := t.parse_test()
+		// if let v = maybe { } unwraps an optional
+		kind := NodeIf
+		var lhs *Node
+		var err error
+		if t.peek() != nil && t.peek().Type == tokeniser.Let {
+			kind = NodeIfLet
+			lhs, err = t.parse_if_let()
+		} else {
+			lhs, err = t.parse_test()
+		}
 
 		if err != nil {
 			return nil, err
@@ -288,18 +1530,79 @@ func (t *Parser) parse_stmt() (*Node, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &Node{Type: NodeIf, Lhs: lhs, Stmts: stmts}, nil
+
+		// the else branch is either a scope or another if
+		var otherwise *Node
+		if t.peek() != nil && t.peek().Type == tokeniser.Else {
+			t.consume()
+			if t.peek() != nil && t.peek().Type == tokeniser.If {
+				otherwise, err = t.parse_stmt()
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				else_stmts, err := t.parse_scope()
+				if err != nil {
+					return nil, err
+				}
+				otherwise = &Node{Type: NodeScope, Stmts: else_stmts}
+			}
+		}
+		return &Node{Type: kind, Lhs: lhs, Rhs: otherwise, Stmts: stmts}, nil
+
+	case tokeniser.Match:
+		return t.parse_match()
 
 	case tokeniser.Identifier:
 		id, err := t.parse_identifier()
 		if err != nil {
 			return nil, err
 		}
-		if id.Type == NodeIdentifier {
+		if t.peek() != nil && (id.Type == NodeIdentifier || id.Type == NodeIndex || id.Type == NodeField) {
+			// compound assignments, x += 1, have the operator as their value
+			// and x++ is short for x += 1
+			switch t.peek().Type {
+			case tokeniser.AssignOp:
+				op := t.consume()
+				rhs, err := t.parse_expr(0)
+				if err != nil {
+					return nil, err
+				}
+				if rhs == nil {
+					return nil, ParseError("expected expression", op)
+				}
+				return &Node{Type: NodeAssign, Value: op.Value, Lhs: id, Rhs: rhs, Line: op.Line, Col: op.Col}, nil
+			case tokeniser.Increment, tokeniser.Decrement:
+				tok := t.consume()
+				op := "+"
+				if tok.Type == tokeniser.Decrement {
+					op = "-"
+				}
+				return &Node{Type: NodeAssign, Value: op, Lhs: id, Rhs: &Node{Type: NodeIntLiteral, Value: "1"}, Line: tok.Line, Col: tok.Col}, nil
+			}
+		}
+		if id.Type == NodeIndex || id.Type == NodeField {
+			// only assignment is allowed to an element or a field
+			if t.peek() == nil || t.peek().Type != tokeniser.Assign {
+				return nil, ParseError("expected '='", &t.Tokens[t.index-1])
+			}
+		}
+		if id.Type == NodeIdentifier || id.Type == NodeIndex || id.Type == NodeField {
 			lhs := id
+			if id.Type == NodeIdentifier && t.peek() != nil && t.peek().Type == tokeniser.Comma {
+				lhs, err = t.parse_targets(id)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if t.peek() == nil {
+				return nil, fmt.Errorf("unexpected EOF")
+			}
 			node := Node{}
 			if t.peek().Type == tokeniser.LetOp {
+				// x := 1 is short for var x = 1
 				node.Type = NodeLet
+				node.Value = "var"
 			} else if t.peek().Type == tokeniser.Assign {
 				node.Type = NodeAssign
 			} else {
@@ -318,6 +1621,9 @@ func (t *Parser) parse_stmt() (*Node, error) {
 		return id, nil
 
 	case tokeniser.For:
+		if t.is_for_in() {
+			return t.parse_for_in()
+		}
 		t.consume()
 		lhs, err := t.parse_test()
 		if err != nil {
@@ -326,13 +1632,87 @@ func (t *Parser) parse_stmt() (*Node, error) {
 		stmts, _ := t.parse_scope()
 		return &Node{Type: NodeFor, Lhs: lhs, Stmts: stmts}, nil
 
+	case tokeniser.Fn:
+		return t.parse_function()
+
+	case tokeniser.Struct:
+		return t.parse_struct()
+
+	case tokeniser.Impl:
+		return t.parse_impl()
+
+	case tokeniser.Interface:
+		return t.parse_interface()
+
+	case tokeniser.Union:
+		return t.parse_union()
+
+	case tokeniser.Return:
+		t.consume()
+		var lhs *Node
+		if t.peek() != nil && t.peek().Type != tokeniser.Rcurly {
+			params, err := t.parse_params()
+			if err != nil {
+				return nil, err
+			}
+			lhs = params
+		}
+		return &Node{Type: NodeReturn, Lhs: lhs}, nil
+
+	case tokeniser.Defer:
+		c := t.consume()
+		stmt, err := t.parse_stmt()
+		if err != nil {
+			return nil, err
+		}
+		if stmt == nil {
+			return nil, ParseError("expected statement after defer", c)
+		}
+		return &Node{Type: NodeDefer, Lhs: stmt}, nil
+
+	case tokeniser.Break:
+		t.consume()
+		return &Node{Type: NodeBreak}, nil
+
+	case tokeniser.Try:
+		return t.parse_term()
+
+	case tokeniser.Assert:
+		c := t.consume()
+		if t.peek() == nil {
+			return nil, ParseError("unexpected eof after assert", c)
+		}
+
+		lhs, err := t.parse_test()
+		if err != nil {
+			return nil, err
+		}
+		if lhs == nil {
+			return nil, ParseError("expected condition after assert", c)
+		}
+
+		// the message is optional, and held in a NodeParam like a print argument
+		var rhs *Node
+		if t.peek() != nil && t.peek().Type == tokeniser.Comma {
+			t.consume()
+			message, err := t.parse_expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if message == nil {
+				return nil, ParseError("expected message after ','", c)
+			}
+			rhs = &Node{Type: NodeParam, Lhs: message}
+		}
+		return &Node{Type: NodeAssert, Lhs: lhs, Rhs: rhs, Value: Format(lhs)}, nil
+
 	case tokeniser.Print:
 		id := t.consume()
 		if t.peek() == nil {
 			return nil, ParseError("unexpected eof after print", id)
 		}
 
-		lhs, err := t.parse_expr(0)
+		lhs, err := t.parse_params()
 		if err != nil {
 			return nil, err
 		}
@@ -345,7 +1725,7 @@ func (t *Parser) parse_stmt() (*Node, error) {
 			return nil, ParseError("unexpected eof after print", id)
 		}
 
-		lhs, err := t.parse_expr(0)
+		lhs, err := t.parse_params()
 		if err != nil {
 			return nil, err
 		}
@@ -403,3 +1783,108 @@ func (t *Parser) Parse() (*StatementSequence, error) {
 
 	return &stmts, nil
 }
+
+var operators = map[NodeType]string{
+	NodeAdd:   "+",
+	NodeSub:   "-",
+	NodeMulti: "*",
+	NodeDiv:   "/",
+	NodeMod:   "%",
+	NodeLt:    "<",
+	NodeGt:    ">",
+	NodeEq:    "==",
+}
+
+func get_node_prec(ty NodeType) int {
+	switch ty {
+	case NodeLt, NodeGt, NodeEq:
+		return -1
+	case NodeAdd, NodeSub:
+		return 0
+	case NodeMulti, NodeDiv, NodeMod:
+		return 1
+	}
+	return 2
+}
+
+// Format renders an expression back into source code, for use in messages.
+func Format(node *Node) string {
+	switch node.Type {
+	case NodeIntLiteral, NodeIdentifier:
+		return node.Value
+	case NodeStringLiteral:
+		return "\"" + node.Value + "\""
+	case NodeNone:
+		return "none"
+	case NodeSpread:
+		return Format(node.Lhs) + "..."
+	case NodeInterpolate:
+		text := "\""
+		for part := node.Lhs; part != nil; part = part.Rhs {
+			if part.Lhs.Type == NodeStringLiteral {
+				text += part.Lhs.Value
+			} else {
+				text += "{" + Format(part.Lhs) + "}"
+			}
+		}
+		return text + "\""
+	case NodeCall:
+		text := node.Value + "("
+		for param := node.Rhs; param != nil; param = param.Rhs {
+			text += Format(param.Lhs)
+			if param.Rhs != nil {
+				text += ", "
+			}
+		}
+		return text + ")"
+	case NodeTry:
+		return "try " + Format(node.Lhs)
+	case NodeIndex:
+		return Format(node.Lhs) + "[" + Format(node.Rhs) + "]"
+	case NodeField:
+		return Format(node.Lhs) + "." + node.Value
+	case NodeMethodCall:
+		text := Format(node.Lhs) + "." + node.Value + "("
+		for param := node.Rhs; param != nil; param = param.Rhs {
+			text += Format(param.Lhs)
+			if param.Rhs != nil {
+				text += ", "
+			}
+		}
+		return text + ")"
+	case NodeIfExpr:
+		text := "if " + Format(node.Lhs) + " { " + Format(node.Rhs.Lhs) + " } else "
+		if node.Rhs.Rhs.Type == NodeIfExpr {
+			return text + Format(node.Rhs.Rhs)
+		}
+		return text + "{ " + Format(node.Rhs.Rhs) + " }"
+	case NodeSlice:
+		text := Format(node.Lhs) + "["
+		if node.Rhs.Lhs != nil {
+			text += Format(node.Rhs.Lhs)
+		}
+		text += ":"
+		if node.Rhs.Rhs != nil {
+			text += Format(node.Rhs.Rhs)
+		}
+		return text + "]"
+	}
+
+	op, ok := operators[node.Type]
+	if !ok {
+		return "?"
+	}
+	if node.Type == NodeGt && node.Line == 0 {
+		// made up by parse_test for a bare expression, so it isn't in the source
+		return Format(node.Lhs)
+	}
+
+	lhs, rhs := Format(node.Lhs), Format(node.Rhs)
+	if get_node_prec(node.Lhs.Type) < get_node_prec(node.Type) {
+		lhs = "(" + lhs + ")"
+	}
+	if get_node_prec(node.Rhs.Type) <= get_node_prec(node.Type) {
+		rhs = "(" + rhs + ")"
+	}
+	return lhs + " " + op + " " + rhs
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"strconv"
+	"strings"
 	"testing"
 
 	"longden.me/blang/tokeniser"
@@ -102,3 +103,902 @@ func TestExprParenInvalid(t *testing.T) {
 		t.Errorf("expected invalid expression error")
 	}
 }
+
+func TestCallWithMultipleArguments(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("write(2, \"oops\", 4)"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeCall || node.Value != "write" {
+		t.Fatalf("expected call to write")
+	}
+
+	count := 0
+	for param := node.Rhs; param != nil; param = param.Rhs {
+		if param.Type != NodeParam {
+			t.Errorf("expected param node")
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("expected 3 arguments, got %d", count)
+	}
+}
+
+func TestCallWithMissingArgument(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("write(2, )"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected invalid expression error")
+	}
+}
+
+func TestPrintWithInterpolationAndMultipleArguments(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("println \"fib = {code}, step {i}\", 1"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodePrintln || node.Lhs == nil || node.Lhs.Rhs == nil || node.Lhs.Rhs.Rhs != nil {
+		t.Fatalf("expected println with two arguments")
+	}
+
+	str := node.Lhs.Lhs
+	if str.Type != NodeInterpolate {
+		t.Fatalf("expected interpolated string")
+	}
+
+	expected := []NodeType{NodeStringLiteral, NodeIdentifier, NodeStringLiteral, NodeIdentifier}
+	i := 0
+	for part := str.Lhs; part != nil; part = part.Rhs {
+		if i >= len(expected) || part.Lhs.Type != expected[i] {
+			t.Errorf("unexpected part %d in interpolated string", i)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d parts, got %d", len(expected), i)
+	}
+}
+
+func TestFunctionWithMultipleResults(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn divmod(a int, b int) (int, int) { return a / b, a - a / b * b }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeFunction || node.Value != "divmod" {
+		t.Fatalf("expected function divmod")
+	}
+
+	if node.Lhs == nil || node.Lhs.Lhs.Value != "a" || node.Lhs.Lhs.Lhs.Value != "int" || node.Lhs.Rhs.Lhs.Value != "b" {
+		t.Errorf("expected parameters a int, b int")
+	}
+
+	if node.Rhs == nil || node.Rhs.Rhs == nil || node.Rhs.Rhs.Rhs != nil {
+		t.Errorf("expected two results")
+	}
+
+	ret := node.Stmts.Statements[0]
+	if ret.Type != NodeReturn || ret.Lhs == nil || ret.Lhs.Rhs == nil {
+		t.Errorf("expected return of two values")
+	}
+}
+
+func TestFunctionWithSingleResult(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn double(a int) int { return a * 2 }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs == nil || node.Rhs.Lhs.Value != "int" || node.Rhs.Rhs != nil {
+		t.Errorf("expected a single int result")
+	}
+}
+
+func TestDestructuringLet(t *testing.T) {
+	for _, src := range []string{"q, r := divmod(x, y)", "let q, r = divmod(x, y)"} {
+		tokens, _ := tokeniser.Tokenise([]byte(src))
+		p := Parser{Tokens: tokens}
+		node, err := p.parse_stmt()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if node.Type != NodeLet || node.Lhs.Type != NodeTuple {
+			t.Fatalf("expected let with a tuple for (%s)", src)
+		}
+
+		if node.Lhs.Lhs.Lhs.Value != "q" || node.Lhs.Lhs.Rhs.Lhs.Value != "r" {
+			t.Errorf("expected q and r to be declared")
+		}
+	}
+}
+
+func TestDeferWrapsStatement(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("defer close(fd)"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeDefer || node.Lhs == nil || node.Lhs.Type != NodeCall {
+		t.Errorf("expected deferred call")
+	}
+}
+
+func TestResultType(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn parse(s string) Result[int] { return 1 }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result := node.Rhs.Lhs
+	if result.Type != NodeTypeName || result.Value != "Result" || result.Lhs == nil || result.Lhs.Lhs.Value != "int" {
+		t.Errorf("expected Result[int]")
+	}
+}
+
+func TestTryExpectsCall(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("x := try parse(s) + 1"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Type != NodeAdd || node.Rhs.Lhs.Type != NodeTry || node.Rhs.Lhs.Lhs.Type != NodeCall {
+		t.Errorf("expected try to apply to the call")
+	}
+
+	tokens, _ = tokeniser.Tokenise([]byte("x := try 1"))
+	p = Parser{Tokens: tokens}
+	_, err = p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for try without a call")
+	}
+}
+
+func TestTryMethodCall(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("x := try r.next()"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Type != NodeTry || node.Rhs.Lhs.Type != NodeMethodCall || node.Rhs.Lhs.Value != "next" {
+		t.Errorf("expected try to apply to the method call")
+	}
+}
+
+func TestAssertKeepsSourceText(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("x := 1\n  assert (x + 1) * 2 == f(x, \"a\"), \"oops\""))
+	p := Parser{Tokens: tokens}
+	p.parse_stmt()
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeAssert || node.Rhs == nil {
+		t.Fatalf("expected assert with a message")
+	}
+
+	if node.Line != 2 || node.Col != 2 {
+		t.Errorf("expected assert at line 2, col 2 (got %d, %d)", node.Line, node.Col)
+	}
+
+	expected := "(x + 1) * 2 == f(x, \"a\")"
+	if node.Value != expected {
+		t.Errorf("expected text %s, got %s", expected, node.Value)
+	}
+}
+
+func TestAssertBareExpression(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("assert x - (y - 1)"))
+	p := Parser{Tokens: tokens}
+	node, _ := p.parse_stmt()
+	if node.Value != "x - (y - 1)" {
+		t.Errorf("unexpected text %s", node.Value)
+	}
+}
+
+func TestSliceLiteral(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("s := []int{1, 2 + 3}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	literal := node.Rhs
+	if literal.Type != NodeSliceLiteral || literal.Lhs.Type != NodeSliceType {
+		t.Fatalf("expected slice literal")
+	}
+
+	if literal.Rhs.Lhs.Type != NodeIntLiteral || literal.Rhs.Rhs.Lhs.Type != NodeAdd {
+		t.Errorf("expected two elements")
+	}
+}
+
+func TestIndexAndSubSlice(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("x := s[i + 1] + len(s[:2])"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Lhs.Type != NodeIndex || node.Rhs.Lhs.Rhs.Type != NodeAdd {
+		t.Errorf("expected index")
+	}
+
+	slice := node.Rhs.Rhs.Rhs.Lhs
+	if slice.Type != NodeSlice || slice.Rhs.Lhs != nil || slice.Rhs.Rhs.Value != "2" {
+		t.Errorf("expected slice with only a high bound")
+	}
+
+	if Format(node.Rhs) != "s[i + 1] + len(s[:2])" {
+		t.Errorf("unexpected text %s", Format(node.Rhs))
+	}
+}
+
+func TestAssignToIndex(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("s[0] = 1"))
+	p := Parser{Tokens: tokens}
+	node, _ := p.parse_stmt()
+	if node.Type != NodeAssign || node.Lhs.Type != NodeIndex {
+		t.Errorf("expected assignment to index")
+	}
+
+	tokens, _ = tokeniser.Tokenise([]byte("s[0]"))
+	p = Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for index without assignment")
+	}
+}
+
+func TestMapLiteral(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("m := map[string][]int{\"a\": x, \"b\": []int{}}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	literal := node.Rhs
+	if literal.Type != NodeMapLiteral || literal.Lhs.Type != NodeMapType {
+		t.Fatalf("expected map literal")
+	}
+
+	if literal.Lhs.Lhs.Value != "string" || literal.Lhs.Rhs.Type != NodeSliceType {
+		t.Errorf("expected map from string to slice")
+	}
+
+	second := literal.Rhs.Rhs.Lhs
+	if second.Type != NodeEntry || second.Lhs.Value != "b" || second.Rhs.Type != NodeSliceLiteral {
+		t.Errorf("expected second entry")
+	}
+}
+
+func TestMapLiteralMissingValue(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("m := map[string]int{\"a\"}"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for entry without a value")
+	}
+}
+
+func TestBindings(t *testing.T) {
+	expected := map[string]string{"let x = 1": "let", "var x = 1": "var", "x := 1": "var"}
+	for src, binding := range expected {
+		tokens, _ := tokeniser.Tokenise([]byte(src))
+		p := Parser{Tokens: tokens}
+		node, err := p.parse_stmt()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if node.Type != NodeLet || node.Value != binding {
+			t.Errorf("expected %s binding for (%s), got %s", binding, src, node.Value)
+		}
+
+		if node.Lhs.Col != strings.Index(src, "x") {
+			t.Errorf("expected position of x to be kept for (%s)", src)
+		}
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("s[i] *= x % 3"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeAssign || node.Value != "*" || node.Lhs.Type != NodeIndex {
+		t.Errorf("expected compound assignment to an element")
+	}
+
+	if node.Rhs.Type != NodeMod {
+		t.Errorf("expected remainder, got %d", node.Rhs.Type)
+	}
+}
+
+func TestIncrementAndDecrement(t *testing.T) {
+	for src, op := range map[string]string{"i++": "+", "i--": "-"} {
+		tokens, _ := tokeniser.Tokenise([]byte(src))
+		p := Parser{Tokens: tokens}
+		node, err := p.parse_stmt()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if node.Type != NodeAssign || node.Value != op || node.Rhs.Value != "1" {
+			t.Errorf("expected %s to be parsed as i %s= 1", src, op)
+		}
+	}
+}
+
+func TestIfElseChain(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("if x < 0 { exit 1 } else if x == 0 { exit 2 } else { exit 3 }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeIf || node.Rhs == nil || node.Rhs.Type != NodeIf {
+		t.Fatalf("expected else if")
+	}
+
+	if node.Rhs.Rhs == nil || node.Rhs.Rhs.Type != NodeScope {
+		t.Errorf("expected final else")
+	}
+}
+
+func TestIfExpression(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("let m = if a > b { a } else if b > c { b } else { c }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Type != NodeIfExpr || node.Rhs.Rhs.Type != NodeBranches {
+		t.Fatalf("expected if expression")
+	}
+
+	expected := "if a > b { a } else if b > c { b } else { c }"
+	if Format(node.Rhs) != expected {
+		t.Errorf("expected %s, got %s", expected, Format(node.Rhs))
+	}
+}
+
+func TestIfExpressionNeedsElse(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("let m = if a > b { a }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for if expression without else")
+	}
+}
+
+func TestStruct(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("struct Point {\n x int, y int\n name string\n}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeStruct || node.Value != "Point" {
+		t.Fatalf("expected struct Point")
+	}
+
+	names := []string{}
+	for field := node.Lhs; field != nil; field = field.Rhs {
+		names = append(names, field.Lhs.Value+" "+field.Lhs.Lhs.Value)
+	}
+	if strings.Join(names, ", ") != "x int, y int, name string" {
+		t.Errorf("unexpected fields %v", names)
+	}
+}
+
+func TestStructLiteral(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("let p = Point{x: 1, y: a + 2}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Type != NodeStructLiteral || node.Rhs.Value != "Point" {
+		t.Fatalf("expected struct literal")
+	}
+	if node.Rhs.Rhs.Lhs.Type != NodeEntry || node.Rhs.Rhs.Lhs.Lhs.Value != "x" {
+		t.Errorf("expected x as the first field")
+	}
+	if node.Rhs.Rhs.Rhs.Lhs.Rhs.Type != NodeAdd {
+		t.Errorf("expected a + 2 as the value of y")
+	}
+}
+
+func TestBlockIsNotStructLiteral(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("if a > b { a = b }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeIf || node.Lhs.Rhs.Type != NodeIdentifier {
+		t.Errorf("expected if statement comparing with b")
+	}
+}
+
+func TestFieldAndMethodCall(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("let d = a.b.dist(c.x, 2)"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Type != NodeMethodCall || node.Rhs.Lhs.Type != NodeField {
+		t.Fatalf("expected method call on a field")
+	}
+
+	expected := "a.b.dist(c.x, 2)"
+	if Format(node.Rhs) != expected {
+		t.Errorf("expected %s, got %s", expected, Format(node.Rhs))
+	}
+}
+
+func TestImpl(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("impl Point {\n fn len(self) int { return self.x }\n fn scale(self, by int) { self.x *= by }\n}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeImpl || len(node.Stmts.Statements) != 2 {
+		t.Fatalf("expected impl with two methods")
+	}
+
+	self := node.Stmts.Statements[0].Lhs.Lhs
+	if self.Value != "self" || self.Lhs == nil || self.Lhs.Value != "Point" {
+		t.Errorf("expected self to have type Point")
+	}
+}
+
+func TestImplNeedsSelf(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("impl Point { fn len(p Point) int { return 1 } }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for method without self")
+	}
+}
+
+func TestInterface(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("interface Writer {\n fn write(self, s string) int\n fn flush(self)\n fn close(self) }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeInterface || len(node.Stmts.Statements) != 3 {
+		t.Fatalf("expected interface with three methods")
+	}
+
+	write := node.Stmts.Statements[0]
+	if write.Value != "write" || write.Stmts != nil || write.Rhs.Lhs.Value != "int" {
+		t.Errorf("expected signature of write")
+	}
+	if write.Lhs.Lhs.Lhs.Value != "Writer" || write.Lhs.Rhs.Lhs.Value != "s" {
+		t.Errorf("expected self to have type Writer, followed by s")
+	}
+	if node.Stmts.Statements[1].Rhs != nil {
+		t.Errorf("expected flush to return nothing")
+	}
+}
+
+func TestInterfaceNeedsSelf(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("interface Writer { fn write(s string) }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for method without self")
+	}
+}
+
+func TestGenericFunction(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn max[T](a T, b T) T { return a }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeGeneric || node.Value != "max" || node.Lhs.Lhs.Value != "T" || node.Lhs.Rhs != nil {
+		t.Fatalf("expected generic max with type parameter T")
+	}
+	if node.Rhs.Type != NodeFunction || node.Rhs.Stmts == nil || node.Rhs.Lhs.Lhs.Lhs.Value != "T" {
+		t.Errorf("expected function taking a T")
+	}
+}
+
+func TestGenericStructAndImpl(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("struct Pair[A, B] { left A, right B }\nimpl Pair[A, B] { fn left(self) A { return self.left } }"))
+	p := Parser{Tokens: tokens}
+	decl, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if decl.Type != NodeGeneric || decl.Rhs.Type != NodeStruct || decl.Lhs.Rhs.Lhs.Value != "B" {
+		t.Fatalf("expected generic struct with type parameters A and B")
+	}
+
+	impl, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if impl.Type != NodeGeneric || impl.Rhs.Type != NodeImpl {
+		t.Fatalf("expected generic impl")
+	}
+	self := impl.Rhs.Stmts.Statements[0].Lhs.Lhs.Lhs
+	if self.Value != "Pair" || self.Lhs.Lhs.Value != "A" || self.Lhs.Rhs.Lhs.Value != "B" {
+		t.Errorf("expected self to have type Pair[A, B]")
+	}
+}
+
+func TestTypeArguments(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn f(m map[string]Pair[int, []string]) { exit 1 }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pair := node.Lhs.Lhs.Lhs.Rhs
+	if pair.Value != "Pair" || pair.Lhs.Lhs.Value != "int" || pair.Lhs.Rhs.Lhs.Type != NodeSliceType {
+		t.Errorf("expected Pair[int, []string]")
+	}
+}
+
+func TestGenericStructLiteral(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("let s = Stack[int]{}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Type != NodeStructLiteral || node.Rhs.Value != "Stack" || node.Rhs.Lhs.Lhs.Value != "int" {
+		t.Errorf("expected literal of Stack[int]")
+	}
+}
+
+func TestIndexIsNotGenericLiteral(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("if flags[i] { exit 1 }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeIf || node.Lhs.Lhs.Type != NodeIndex {
+		t.Errorf("expected if statement testing flags[i]")
+	}
+}
+
+func TestMethodsCantBeGeneric(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("impl Point { fn map[T](self, x T) { exit 1 } }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for method with type parameters")
+	}
+}
+
+func TestOptionalType(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn find(xs []int) ?int { return none }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Rhs.Lhs.Type != NodeOptionalType || node.Rhs.Lhs.Lhs.Value != "int" {
+		t.Errorf("expected find to return ?int")
+	}
+	if node.Stmts.Statements[0].Lhs.Lhs.Type != NodeNone {
+		t.Errorf("expected none to be returned")
+	}
+}
+
+func TestIfLet(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("if let v = find(xs) { exit v } else { exit 1 }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeIfLet || node.Lhs.Type != NodeLet {
+		t.Fatalf("expected if let")
+	}
+	if node.Lhs.Lhs.Value != "v" || node.Lhs.Rhs.Type != NodeCall {
+		t.Errorf("expected v to be declared from the call")
+	}
+	if len(node.Stmts.Statements) != 1 || node.Rhs == nil || node.Rhs.Type != NodeScope {
+		t.Errorf("expected body and else branch")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("match find(xs) {\n Some(v) => { exit v },\n none => exit 1\n _ => exit 2\n}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeMatch || node.Lhs.Type != NodeCall {
+		t.Fatalf("expected match of the call")
+	}
+
+	var arms []*Node
+	for arm := node.Rhs; arm != nil; arm = arm.Rhs {
+		arms = append(arms, arm.Lhs)
+	}
+	if len(arms) != 3 {
+		t.Fatalf("expected three arms, got %d", len(arms))
+	}
+	some := arms[0].Lhs
+	if some.Type != NodeVariant || some.Value != "Some" || some.Lhs.Lhs.Value != "v" {
+		t.Errorf("expected Some(v)")
+	}
+	if arms[1].Lhs.Type != NodeNone || arms[1].Stmts.Statements[0].Type != NodeExit {
+		t.Errorf("expected none with a single statement")
+	}
+	if arms[2].Lhs.Type != NodeIdentifier || arms[2].Lhs.Value != "_" {
+		t.Errorf("expected _")
+	}
+}
+
+func TestMatchNeedsArrow(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("match x { none exit 1 }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for arm without =>")
+	}
+}
+
+func TestFormatNone(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("assert m == none"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if Format(node.Lhs) != "m == none" {
+		t.Errorf("expected m == none, got %s", Format(node.Lhs))
+	}
+}
+
+func TestUnion(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("union Token {\n Int(int),\n Op(string, int)\n Eof }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeUnion || node.Value != "Token" {
+		t.Fatalf("expected union Token")
+	}
+	var variants []*Node
+	for variant := node.Lhs; variant != nil; variant = variant.Rhs {
+		variants = append(variants, variant.Lhs)
+	}
+	if len(variants) != 3 {
+		t.Fatalf("expected three variants, got %d", len(variants))
+	}
+	if variants[0].Value != "Int" || variants[0].Lhs.Lhs.Value != "int" || variants[0].Lhs.Rhs != nil {
+		t.Errorf("expected Int(int)")
+	}
+	if variants[1].Value != "Op" || variants[1].Lhs.Rhs.Lhs.Value != "int" {
+		t.Errorf("expected Op(string, int)")
+	}
+	if variants[2].Value != "Eof" || variants[2].Lhs != nil {
+		t.Errorf("expected Eof without values")
+	}
+}
+
+func TestUnionNeedsVariants(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("union Token { }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for union without variants")
+	}
+}
+
+func TestMatchVariantValues(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("match t { Op(s, _) => exit 1\n Eof => exit 2 }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	op := node.Rhs.Lhs.Lhs
+	if op.Type != NodeVariant || op.Lhs.Lhs.Value != "s" || op.Lhs.Rhs.Lhs.Value != "_" {
+		t.Errorf("expected Op(s, _)")
+	}
+	if eof := node.Rhs.Rhs.Lhs.Lhs; eof.Type != NodeIdentifier || eof.Value != "Eof" {
+		t.Errorf("expected Eof")
+	}
+}
+
+func TestVariadicFunction(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn log(level int, parts ...string) { exit level }"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parts := node.Lhs.Rhs.Lhs
+	if parts.Value != "parts" || parts.Lhs.Type != NodeVariadicType || parts.Lhs.Lhs.Value != "string" {
+		t.Errorf("expected parts to be ...string")
+	}
+}
+
+func TestOnlyLastParameterIsVariadic(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("fn log(parts ...string, level int) { exit level }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for variadic parameter before another")
+	}
+}
+
+func TestSpreadArgument(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("log(1, parts...)"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	spread := node.Rhs.Rhs.Lhs
+	if spread.Type != NodeSpread || spread.Lhs.Value != "parts" {
+		t.Errorf("expected parts to be passed on with ...")
+	}
+}
+
+func TestLetStructPattern(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("let Point{x, y: Some(n)} = p"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeLet || node.Lhs.Type != NodeStructPattern || node.Lhs.Value != "Point" {
+		t.Fatalf("expected let with a Point pattern")
+	}
+	x := node.Lhs.Rhs.Lhs
+	if x.Type != NodeField || x.Value != "x" || x.Lhs.Type != NodeIdentifier || x.Lhs.Value != "x" {
+		t.Errorf("expected x bound to x")
+	}
+	y := node.Lhs.Rhs.Rhs.Lhs
+	if y.Value != "y" || y.Lhs.Type != NodeVariant || y.Lhs.Lhs.Lhs.Value != "n" {
+		t.Errorf("expected y matched by Some(n)")
+	}
+	if node.Rhs.Type != NodeIdentifier || node.Rhs.Value != "p" {
+		t.Errorf("expected p as the value")
+	}
+}
+
+func TestLetTuplePattern(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("let (a, _) = divmod(x, y)"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeLet || node.Lhs.Type != NodeTuple {
+		t.Fatalf("expected let of a tuple")
+	}
+	if node.Lhs.Lhs.Lhs.Value != "a" || node.Lhs.Lhs.Rhs.Lhs.Value != "_" {
+		t.Errorf("expected a and _ as targets")
+	}
+}
+
+func TestMatchGuard(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("match e {\n Add(Num(0), b) => exit 0\n Some(n) if n > 0 => exit n\n}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	add := node.Rhs.Lhs
+	if add.Rhs != nil {
+		t.Errorf("expected no guard on the first arm")
+	}
+	num := add.Lhs.Lhs.Lhs
+	if num.Type != NodeVariant || num.Value != "Num" || num.Lhs.Lhs.Type != NodeIntLiteral {
+		t.Errorf("expected Num(0) nested in Add")
+	}
+	some := node.Rhs.Rhs.Lhs
+	if some.Lhs.Value != "Some" || some.Rhs == nil || some.Rhs.Type != NodeGt {
+		t.Errorf("expected Some(n) guarded by n > 0")
+	}
+}
+
+func TestForIn(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("for i, x in xs[1:] {\n println x\n}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if node.Type != NodeForIn || node.Lhs.Type != NodeTuple {
+		t.Fatalf("expected for in with targets")
+	}
+	if node.Lhs.Lhs.Lhs.Value != "i" || node.Lhs.Lhs.Rhs.Lhs.Value != "x" {
+		t.Errorf("expected i and x as targets")
+	}
+	if node.Rhs.Type != NodeSlice || len(node.Stmts.Statements) != 1 {
+		t.Errorf("expected a loop over a slice of xs with one statement")
+	}
+}
+
+func TestForTestStillParses(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("for i < n {\n i++\n}"))
+	p := Parser{Tokens: tokens}
+	node, err := p.parse_stmt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if node.Type != NodeFor || node.Lhs.Type != NodeLt {
+		t.Errorf("expected for with a test")
+	}
+}
+
+func TestForInNeedsIn(t *testing.T) {
+	tokens, _ := tokeniser.Tokenise([]byte("for a, b xs { exit 1 }"))
+	p := Parser{Tokens: tokens}
+	_, err := p.parse_stmt()
+	if err == nil {
+		t.Errorf("expected error for missing in")
+	}
+}
@@ -0,0 +1,60 @@
+package scope
+
+// Scope is a block of the program and the variables declared in it. Scopes
+// form a tree, each one can see the variables of the scopes it's nested in.
+//
+// A variable can only be declared once in a scope, but can be declared again
+// in a nested scope, where it hides the outer one until the nested scope ends.
+type Scope[T any] struct {
+	parent *Scope[T]
+	names  []string
+	values []*T
+}
+
+// New creates a scope nested in parent, or an outermost one if parent is nil.
+func New[T any](parent *Scope[T]) *Scope[T] {
+	return &Scope[T]{parent: parent}
+}
+
+func (s *Scope[T]) Parent() *Scope[T] {
+	return s.parent
+}
+
+// Declare adds a variable to the scope. It returns the variable already
+// declared with the same name in this scope, if there is one, instead.
+func (s *Scope[T]) Declare(name string, value T) (*T, bool) {
+	if existing := s.Local(name); existing != nil {
+		return existing, false
+	}
+	s.names = append(s.names, name)
+	s.values = append(s.values, &value)
+	return s.values[len(s.values)-1], true
+}
+
+// Local finds a variable declared in this scope, ignoring the scopes it's
+// nested in.
+func (s *Scope[T]) Local(name string) *T {
+	for i := range s.names {
+		if s.names[i] == name {
+			return s.values[i]
+		}
+	}
+	return nil
+}
+
+// Lookup finds the innermost variable with a name, looking out through the
+// scopes this one is nested in.
+func (s *Scope[T]) Lookup(name string) *T {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value := scope.Local(name); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the variables declared in this scope, in the
+// order they were declared.
+func (s *Scope[T]) Names() []string {
+	return s.names
+}
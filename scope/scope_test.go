@@ -0,0 +1,64 @@
+package scope
+
+import "testing"
+
+func TestLookupInNestedScopes(t *testing.T) {
+	outer := New[int](nil)
+	outer.Declare("a", 1)
+	inner := New(outer)
+	inner.Declare("b", 2)
+
+	if v := inner.Lookup("a"); v == nil || *v != 1 {
+		t.Errorf("expected a from the outer scope")
+	}
+	if v := inner.Lookup("b"); v == nil || *v != 2 {
+		t.Errorf("expected b from the inner scope")
+	}
+	if outer.Lookup("b") != nil {
+		t.Errorf("expected b to be invisible from the outer scope")
+	}
+}
+
+func TestShadowing(t *testing.T) {
+	outer := New[int](nil)
+	outer.Declare("a", 1)
+	inner := New(outer)
+	_, ok := inner.Declare("a", 2)
+	if !ok {
+		t.Fatalf("expected a to be declared again in a nested scope")
+	}
+
+	if v := inner.Lookup("a"); *v != 2 {
+		t.Errorf("expected the inner a to hide the outer one (got %d)", *v)
+	}
+
+	if v := inner.Parent().Lookup("a"); *v != 1 {
+		t.Errorf("expected the outer a after the inner scope ends (got %d)", *v)
+	}
+}
+
+func TestRedeclaringInSameScope(t *testing.T) {
+	s := New[int](nil)
+	s.Declare("a", 1)
+	existing, ok := s.Declare("a", 2)
+	if ok {
+		t.Errorf("expected a to be rejected")
+	}
+	if existing == nil || *existing != 1 {
+		t.Errorf("expected the existing declaration of a")
+	}
+	if len(s.Names()) != 1 {
+		t.Errorf("expected one variable in scope (got %d)", len(s.Names()))
+	}
+}
+
+func TestLookupUpdatesVariable(t *testing.T) {
+	outer := New[int](nil)
+	outer.Declare("a", 1)
+	inner := New(New(outer))
+
+	*inner.Lookup("a") = 3
+	if *outer.Local("a") != 3 {
+		t.Errorf("expected the variable to be updated in place")
+	}
+}
@@ -31,6 +31,36 @@ const (
 	Print
 	Println
 	LetOp
+	Comma
+	InterpolateStart
+	InterpolateEnd
+	Fn
+	Return
+	Defer
+	Break
+	Try
+	Lbracket
+	Rbracket
+	Assert
+	Colon
+	Map
+	Var
+	Percent
+	AssignOp // +=, -= and so on, with the operator as the value
+	Increment
+	Decrement
+	Else
+	Dot
+	Struct
+	Impl
+	Interface
+	Question
+	None
+	Match
+	Arrow
+	Union
+	Ellipsis
+	In
 )
 
 type Token struct {
@@ -66,14 +96,17 @@ func (s *source) append(token Token) {
 	s.tokens = append(s.tokens, token)
 }
 
+// operators that can be combined with = to update a variable in place
+var assign_ops = map[TokenType]string{Plus: "+", Minus: "-", Star: "*", Fslash: "/", Percent: "%"}
+
 func Tokenise(data []byte) ([]Token, error) {
 	src := source{src: data, line: 1}
 
 	for src.peek() != 0 {
 		buf := ""
 		t := Token{Col: src.col, Line: src.line}
-		if unicode.IsLetter(rune(src.peek())) {
-			for unicode.IsLetter(rune(src.peek())) || unicode.IsNumber(rune(src.peek())) {
+		if unicode.IsLetter(rune(src.peek())) || src.peek() == '_' {
+			for unicode.IsLetter(rune(src.peek())) || unicode.IsNumber(rune(src.peek())) || src.peek() == '_' {
 				buf += string(src.consume())
 			}
 			switch buf {
@@ -89,6 +122,38 @@ func Tokenise(data []byte) ([]Token, error) {
 				t.Type = Print
 			case "println":
 				t.Type = Println
+			case "fn":
+				t.Type = Fn
+			case "return":
+				t.Type = Return
+			case "defer":
+				t.Type = Defer
+			case "break":
+				t.Type = Break
+			case "try":
+				t.Type = Try
+			case "assert":
+				t.Type = Assert
+			case "map":
+				t.Type = Map
+			case "var":
+				t.Type = Var
+			case "else":
+				t.Type = Else
+			case "struct":
+				t.Type = Struct
+			case "impl":
+				t.Type = Impl
+			case "interface":
+				t.Type = Interface
+			case "none":
+				t.Type = None
+			case "match":
+				t.Type = Match
+			case "union":
+				t.Type = Union
+			case "in":
+				t.Type = In
 			default:
 				t.Type = Identifier
 				t.Value = buf
@@ -109,26 +174,97 @@ func Tokenise(data []byte) ([]Token, error) {
 			continue
 		} else if string(src.peek()) == "\"" {
 			src.consume() // string
+			interpolated := false
 			for string(src.peek()) != "\"" {
+				if src.peek() == 0 {
+					return nil, fmt.Errorf("unterminated string at line %d column %d", t.Line, t.Col)
+				}
+				if string(src.peek()) == "{" {
+					src.consume()
+					if string(src.peek()) == "{" {
+						buf += string(src.consume()) // {{ is a literal brace
+						continue
+					}
+
+					// "a {expr} b" is tokenised as a string, then the tokens
+					// of expr between curly braces, then the rest of the string
+					if !interpolated {
+						src.append(Token{Type: InterpolateStart, Line: t.Line, Col: t.Col})
+						interpolated = true
+					}
+					if buf != "" {
+						src.append(Token{Type: String, Value: buf, Line: t.Line, Col: t.Col})
+						buf = ""
+					}
+
+					line, col := src.line, src.col
+					expr := ""
+					for string(src.peek()) != "}" {
+						if src.peek() == 0 || string(src.peek()) == "\"" {
+							return nil, fmt.Errorf("expected '}' in string at line %d column %d", line, col)
+						}
+						expr += string(src.consume())
+					}
+					src.consume()
+
+					tokens, err := Tokenise([]byte(expr))
+					if err != nil {
+						return nil, err
+					}
+					src.append(Token{Type: Lcurly, Line: line, Col: col - 1})
+					for _, tok := range tokens {
+						tok.Line = line
+						tok.Col += col
+						src.append(tok)
+					}
+					src.append(Token{Type: Rcurly, Line: src.line, Col: src.col - 1})
+					continue
+				}
+				if string(src.peek()) == "}" {
+					src.consume()
+					if string(src.peek()) == "}" {
+						src.consume() // }} is a literal brace too
+					}
+					buf += "}"
+					continue
+				}
 				buf += string(src.consume())
 			}
 			src.consume()
-			t.Type = String
-			t.Value = buf
+			if interpolated {
+				if buf != "" {
+					src.append(Token{Type: String, Value: buf, Line: t.Line, Col: t.Col})
+				}
+				t.Type = InterpolateEnd
+			} else {
+				t.Type = String
+				t.Value = buf
+			}
 		} else if string(src.peek()) == "=" {
 			src.consume()
 			if string(src.peek()) == "=" {
 				src.consume()
 				t.Type = Eq
+			} else if string(src.peek()) == ">" {
+				src.consume()
+				t.Type = Arrow
 			} else {
 				t.Type = Assign
 			}
 		} else if string(src.peek()) == "+" {
 			src.consume()
 			t.Type = Plus
+			if string(src.peek()) == "+" {
+				src.consume()
+				t.Type = Increment
+			}
 		} else if string(src.peek()) == "-" {
 			src.consume()
 			t.Type = Minus
+			if string(src.peek()) == "-" {
+				src.consume()
+				t.Type = Decrement
+			}
 		} else if string(src.peek()) == "*" {
 			src.consume()
 			t.Type = Star
@@ -143,6 +279,9 @@ func Tokenise(data []byte) ([]Token, error) {
 				}
 				continue
 			}
+		} else if string(src.peek()) == "%" {
+			src.consume()
+			t.Type = Percent
 		} else if string(src.peek()) == "(" {
 			src.consume()
 			t.Type = Lparen
@@ -155,23 +294,48 @@ func Tokenise(data []byte) ([]Token, error) {
 		} else if string(src.peek()) == "}" {
 			src.consume()
 			t.Type = Rcurly
+		} else if string(src.peek()) == "[" {
+			src.consume()
+			t.Type = Lbracket
+		} else if string(src.peek()) == "]" {
+			src.consume()
+			t.Type = Rbracket
 		} else if string(src.peek()) == "<" {
 			src.consume()
 			t.Type = Lt
 		} else if string(src.peek()) == ">" {
 			src.consume()
 			t.Type = Gt
+		} else if string(src.peek()) == "," {
+			src.consume()
+			t.Type = Comma
+		} else if string(src.peek()) == "." {
+			src.consume()
+			t.Type = Dot
+			if string(src.peek()) == "." && src.sp+1 < len(src.src) && src.src[src.sp+1] == '.' {
+				src.consume()
+				src.consume()
+				t.Type = Ellipsis
+			}
+		} else if string(src.peek()) == "?" {
+			src.consume()
+			t.Type = Question
 		} else if string(src.peek()) == ":" {
 			src.consume()
 			if string(src.peek()) == "=" {
 				src.consume()
 				t.Type = LetOp
 			} else {
-				return nil, fmt.Errorf("expected '=' at line %d column %d", src.line, src.col)
+				t.Type = Colon
 			}
 		} else {
 			return nil, fmt.Errorf("no idea what this is yet at position %d (%c)", src.sp, src.src[src.sp])
 		}
+		if op, ok := assign_ops[t.Type]; ok && string(src.peek()) == "=" {
+			src.consume()
+			t.Type = AssignOp
+			t.Value = op
+		}
 		src.append(t)
 	}
 
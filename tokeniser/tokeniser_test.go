@@ -13,7 +13,7 @@ func TestExit(t *testing.T) {
 }
 
 func TestValidTokens(t *testing.T) {
-	tokens := "1 a abc + - * / < > let exit if for == ( ) { }"
+	tokens := "1 a abc + - * / < > let exit if for == ( ) { } , fn return defer break try [ ] assert : map var % += -= *= /= %= ++ -- else struct impl . interface ? none match => union ... in"
 	tokenised, _ := Tokenise([]byte(tokens))
 	if len(tokenised) != len(strings.Split(tokens, " ")) {
 		t.Errorf("parsed tokens does not match expected total")
@@ -62,3 +62,60 @@ func TestUnrecognisedTokenGeneratesError(t *testing.T) {
 		t.Errorf("expected error from tokeniser")
 	}
 }
+
+func TestIdentifierWithUnderscore(t *testing.T) {
+	tokens, _ := Tokenise([]byte("read_file"))
+	if len(tokens) != 1 || tokens[0].Type != Identifier || tokens[0].Value != "read_file" {
+		t.Errorf("expected a single identifier token")
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tokens, err := Tokenise([]byte("\"a {x + 1} b\""))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []TokenType{InterpolateStart, String, Lcurly, Identifier, Plus, Int, Rcurly, String, InterpolateEnd}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, ty := range expected {
+		if tokens[i].Type != ty {
+			t.Errorf("token %d: expected type %d, got %d", i, ty, tokens[i].Type)
+		}
+	}
+
+	if tokens[3].Col != 4 {
+		t.Errorf("expected column of interpolated identifier to equal 4 (got %d)", tokens[3].Col)
+	}
+}
+
+func TestEscapedBracesAreNotInterpolated(t *testing.T) {
+	tokens, _ := Tokenise([]byte("\"{{a}}\""))
+	if len(tokens) != 1 || tokens[0].Type != String || tokens[0].Value != "{a}" {
+		t.Errorf("expected a single string token")
+	}
+}
+
+func TestUnterminatedStringGeneratesError(t *testing.T) {
+	_, err := Tokenise([]byte("\"abc"))
+	if err == nil {
+		t.Errorf("expected error from tokeniser")
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	tokens, _ := Tokenise([]byte("x %= 2\ni++\ni--1"))
+	if len(tokens) != 8 {
+		t.Fatalf("expected 8 tokens, got %d", len(tokens))
+	}
+
+	if tokens[1].Type != AssignOp || tokens[1].Value != "%" {
+		t.Errorf("expected %%= to be tokenised as an assignment")
+	}
+
+	if tokens[4].Type != Increment || tokens[6].Type != Decrement {
+		t.Errorf("expected ++ and --")
+	}
+}